@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ginchat/services"
+)
+
+// sha1Line matches a line of a hash list, HASH or HASH:COUNT
+var sha1Line = regexp.MustCompile(`^[0-9A-Fa-f]{40}(:\d+)?$`)
+
+// breached_passwords builds the breached password corpus read by the password
+// policy from a list of plaintext passwords or SHA-1 hashes (HASH:COUNT, as in
+// the Have I Been Pwned downloads), one per line:
+//
+//	go run ./cmd/breached_passwords -out data/breached-passwords < passwords.txt
+//
+// Range files downloaded from Have I Been Pwned can be used as they are.
+func main() {
+	out := flag.String("out", "data/breached-passwords", "directory to write the corpus to")
+	flag.Parse()
+
+	// Count every hash, grouped by the prefix naming its file
+	buckets := make(map[string]map[string]int)
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	lines := 0
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		lines++
+
+		var prefix, suffix string
+		count := 1
+		if sha1Line.MatchString(line) {
+			hash, seen, _ := strings.Cut(strings.ToUpper(line), ":")
+			prefix, suffix = hash[:services.BreachedPrefixLength], hash[services.BreachedPrefixLength:]
+			if n, err := strconv.Atoi(seen); err == nil {
+				count = n
+			}
+		} else {
+			prefix, suffix = services.BreachedPasswordHash(line)
+		}
+
+		if buckets[prefix] == nil {
+			buckets[prefix] = make(map[string]int)
+		}
+		buckets[prefix][suffix] += count
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Failed to read input: %v", err)
+	}
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		log.Fatalf("Failed to create %s: %v", *out, err)
+	}
+
+	for prefix, hashes := range buckets {
+		suffixes := make([]string, 0, len(hashes))
+		for suffix := range hashes {
+			suffixes = append(suffixes, suffix)
+		}
+		sort.Strings(suffixes)
+
+		var b strings.Builder
+		for _, suffix := range suffixes {
+			fmt.Fprintf(&b, "%s:%d\n", suffix, hashes[suffix])
+		}
+
+		path := filepath.Join(*out, prefix+".txt")
+		if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+			log.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	log.Printf("Wrote %d passwords into %d files in %s", lines, len(buckets), *out)
+}
@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/models"
+	"github.com/ginchat/services"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// AccountController handles requests from users changing their own credentials
+type AccountController struct {
+	UserService        *services.UserService
+	TokenService       *services.TokenService
+	EmailChangeService *services.EmailChangeService
+	AuditService       *services.AuditService
+	PasswordPolicy     *services.PasswordPolicy
+	LoginProtection    *services.LoginProtectionService
+	MailThrottle       *services.MailThrottle
+}
+
+// NewAccountController creates a new AccountController
+func NewAccountController(db *gorm.DB, userService *services.UserService, tokenService *services.TokenService, emailChangeService *services.EmailChangeService, auditService *services.AuditService, passwordPolicy *services.PasswordPolicy, loginProtectionService *services.LoginProtectionService, mailThrottle *services.MailThrottle) *AccountController {
+	return &AccountController{
+		UserService:        userService,
+		TokenService:       tokenService,
+		EmailChangeService: emailChangeService,
+		AuditService:       auditService,
+		PasswordPolicy:     passwordPolicy,
+		LoginProtection:    loginProtectionService,
+		MailThrottle:       mailThrottle,
+	}
+}
+
+// ChangePasswordRequest represents the request body for changing the current user's password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// ChangeEmailRequest represents the request body for changing the current user's email
+type ChangeEmailRequest struct {
+	NewEmail string `json:"new_email" binding:"required,email,max=100"`
+	Password string `json:"password" binding:"required"`
+}
+
+// ChangePassword godoc
+// @Summary Change password
+// @Description Set a new password after checking the current one. Every other session is signed out; the current one stays logged in.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body ChangePasswordRequest true "Current and new password"
+// @Success 200 {object} map[string]interface{} "Password changed"
+// @Failure 400 {object} map[string]interface{} "Invalid input, incorrect current password or password rejected by the policy"
+// @Failure 401 {object} map[string]interface{} "User not authenticated"
+// @Failure 423 {object} map[string]interface{} "Account locked after too many failed logins"
+// @Failure 429 {object} map[string]interface{} "Too many failed logins"
+// @Failure 500 {object} map[string]interface{} "Server error"
+// @Router /users/me/password [put]
+func (ac *AccountController) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := ac.currentUser(c)
+	if !ok {
+		return
+	}
+
+	// The session to keep signed in is resolved first, so a failure leaves the password unchanged
+	tokenID, exists := c.Get("token_id")
+	tokenIDStr, ok := tokenID.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	sessionID, err := ac.TokenService.SessionIDForToken(tokenIDStr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find the current session"})
+		return
+	}
+
+	// A stolen access token must not allow unlimited guesses at the current password
+	if block := ac.LoginProtection.Check(user.Email, c.ClientIP()); block != nil {
+		respondLoginBlocked(c, block)
+		return
+	}
+
+	// Validate the password against the password policy
+	if err := ac.PasswordPolicy.Validate(req.NewPassword, user.Username, user.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ac.UserService.ChangePassword(user, req.CurrentPassword, req.NewPassword); err != nil {
+		if err.Error() == "current password is incorrect" {
+			recordLoginFailure(c, ac.LoginProtection, ac.AuditService, user.Email)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is incorrect"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// Sign out every other device; whoever knew the old password may be using one
+	if err := ac.TokenService.RevokeOtherSessions(user.UserID, sessionID, services.RevokeReasonPasswordChange); err != nil {
+		logrus.Errorf("Failed to revoke sessions of user %d after password change: %v", user.UserID, err)
+	}
+
+	recordAudit(c, ac.AuditService, services.AuditPasswordChanged, &user.UserID, "")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+}
+
+// RequestEmailChange godoc
+// @Summary Change email
+// @Description Email a confirmation link to a new address. The account's email changes once the link is opened.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body ChangeEmailRequest true "New email and current password"
+// @Success 202 {object} map[string]interface{} "Confirmation email sent"
+// @Failure 400 {object} map[string]interface{} "Invalid input, incorrect password or unchanged email"
+// @Failure 401 {object} map[string]interface{} "User not authenticated"
+// @Failure 409 {object} map[string]interface{} "Email already in use"
+// @Failure 423 {object} map[string]interface{} "Account locked after too many failed logins"
+// @Failure 429 {object} map[string]interface{} "Too many failed logins or confirmation emails"
+// @Failure 500 {object} map[string]interface{} "Server error"
+// @Router /users/me/email [put]
+func (ac *AccountController) RequestEmailChange(c *gin.Context) {
+	var req ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := ac.currentUser(c)
+	if !ok {
+		return
+	}
+
+	// A stolen access token must not allow unlimited guesses at the password
+	if block := ac.LoginProtection.Check(user.Email, c.ClientIP()); block != nil {
+		respondLoginBlocked(c, block)
+		return
+	}
+
+	if !ac.UserService.VerifyPassword(user.Password, req.Password) {
+		recordLoginFailure(c, ac.LoginProtection, ac.AuditService, user.Email)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is incorrect"})
+		return
+	}
+
+	if wait := ac.MailThrottle.Allow(c.ClientIP()); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many confirmation emails, please try again later"})
+		return
+	}
+
+	if wait, err := ac.EmailChangeService.RequestChange(user, req.NewEmail); err != nil {
+		switch err.Error() {
+		case "email unchanged":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "New email is the same as the current one"})
+		case "user with this email already exists":
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case "too many confirmation emails":
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many confirmation emails, please try again later"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	recordAudit(c, ac.AuditService, services.AuditEmailChangeRequested, &user.UserID, req.NewEmail)
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "A confirmation link has been sent to the new email address"})
+}
+
+// ConfirmEmailChange godoc
+// @Summary Confirm an email change
+// @Description Swap the account's email for the address a confirmation link was sent to. Browsers are redirected to the login page.
+// @Tags auth
+// @Produce json
+// @Param token query string true "Confirmation token"
+// @Success 200 {object} map[string]interface{} "Email changed"
+// @Failure 400 {object} map[string]interface{} "Invalid or expired token"
+// @Failure 409 {object} map[string]interface{} "Email already in use"
+// @Router /auth/email/confirm [get]
+func (ac *AccountController) ConfirmEmailChange(c *gin.Context) {
+	// Links opened from an email land on the frontend rather than on raw JSON
+	browser := strings.Contains(c.GetHeader("Accept"), "text/html")
+
+	user, oldEmail, err := ac.EmailChangeService.ConfirmChange(c.Query("token"))
+	if err != nil {
+		if browser {
+			c.Redirect(http.StatusSeeOther, services.AppURL()+"/auth/login?session=email_change_failed")
+			return
+		}
+		switch err.Error() {
+		case "invalid or expired token":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired confirmation token"})
+		case "user with this email already exists":
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	recordAudit(c, ac.AuditService, services.AuditEmailChanged, &user.UserID, oldEmail+" -> "+user.Email)
+
+	if browser {
+		c.Redirect(http.StatusSeeOther, services.AppURL()+"/auth/login?session=email_changed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email changed successfully",
+		"user":    ac.UserService.ToResponse(user),
+	})
+}
+
+// currentUser loads the authenticated user, writing an error response if that fails
+func (ac *AccountController) currentUser(c *gin.Context) (*models.User, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, false
+	}
+
+	user, err := ac.UserService.GetUserByID(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return user, true
+}
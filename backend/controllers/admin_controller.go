@@ -0,0 +1,402 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/models"
+	"github.com/ginchat/services"
+	"gorm.io/gorm"
+)
+
+// AdminController handles user management requests from admins
+type AdminController struct {
+	UserService          *services.UserService
+	TokenService         *services.TokenService
+	AuthorizationService *services.AuthorizationService
+	AuditService         *services.AuditService
+	PasswordResetService *services.PasswordResetService
+	LoginProtection      *services.LoginProtectionService
+}
+
+// NewAdminController creates a new AdminController
+func NewAdminController(db *gorm.DB, userService *services.UserService, tokenService *services.TokenService, authorizationService *services.AuthorizationService, auditService *services.AuditService, passwordResetService *services.PasswordResetService, loginProtection *services.LoginProtectionService) *AdminController {
+	return &AdminController{
+		UserService:          userService,
+		TokenService:         tokenService,
+		AuthorizationService: authorizationService,
+		AuditService:         auditService,
+		PasswordResetService: passwordResetService,
+		LoginProtection:      loginProtection,
+	}
+}
+
+// UpdateRoleRequest represents the request body for changing a user's role
+type UpdateRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// ListUsers godoc
+// @Summary List users
+// @Description Search users by username or email with pagination
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param q query string false "Username or email search"
+// @Param role query string false "Role filter"
+// @Param status query string false "Account status filter (active or disabled)"
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Page size (max 100)" default(20)
+// @Success 200 {object} map[string]interface{} "Users"
+// @Failure 403 {object} map[string]interface{} "Insufficient permissions"
+// @Failure 500 {object} map[string]interface{} "Server error"
+// @Router /admin/users [get]
+func (ac *AdminController) ListUsers(c *gin.Context) {
+	page, pageSize := pagination(c)
+
+	users, total, err := ac.UserService.SearchUsers(c.Query("q"), c.Query("role"), c.Query("status"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	responses := make([]models.AdminUserResponse, 0, len(users))
+	for i := range users {
+		responses = append(responses, ac.UserService.ToAdminResponse(&users[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":     responses,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// GetUser godoc
+// @Summary Get a user
+// @Description Get a user's profile, account state and active sessions
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]interface{} "User and sessions"
+// @Failure 400 {object} map[string]interface{} "Invalid user ID"
+// @Failure 403 {object} map[string]interface{} "Insufficient permissions"
+// @Failure 404 {object} map[string]interface{} "User not found"
+// @Router /admin/users/{id} [get]
+func (ac *AdminController) GetUser(c *gin.Context) {
+	user, ok := ac.targetUser(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := ac.TokenService.ListSessions(user.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sessionResponses := make([]models.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		sessionResponses = append(sessionResponses, session.ToResponse(false))
+	}
+
+	recordAudit(c, ac.AuditService, services.AuditUserViewed, &user.UserID, "")
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":     ac.UserService.ToAdminResponse(user),
+		"sessions": sessionResponses,
+	})
+}
+
+// UpdateRole godoc
+// @Summary Change a user's role
+// @Description Change a user's role; the new role applies to their next request
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "User ID"
+// @Param role body UpdateRoleRequest true "New role"
+// @Success 200 {object} map[string]interface{} "Role updated"
+// @Failure 400 {object} map[string]interface{} "Invalid input"
+// @Failure 403 {object} map[string]interface{} "Insufficient permissions"
+// @Failure 404 {object} map[string]interface{} "User not found"
+// @Router /admin/users/{id}/role [put]
+func (ac *AdminController) UpdateRole(c *gin.Context) {
+	var req UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !services.IsValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	user, ok := ac.otherUser(c)
+	if !ok {
+		return
+	}
+	previousRole := user.Role
+
+	user, err := ac.UserService.SetRole(user.UserID, req.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ac.AuthorizationService.Invalidate(user.UserID)
+
+	recordAudit(c, ac.AuditService, services.AuditRoleChanged, &user.UserID, fmt.Sprintf("%s -> %s", previousRole, req.Role))
+
+	c.JSON(http.StatusOK, gin.H{"user": ac.UserService.ToAdminResponse(user)})
+}
+
+// DisableUser godoc
+// @Summary Disable a user
+// @Description Disable a user's account, signing them out everywhere
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]interface{} "User disabled"
+// @Failure 400 {object} map[string]interface{} "Invalid user ID"
+// @Failure 403 {object} map[string]interface{} "Insufficient permissions"
+// @Failure 404 {object} map[string]interface{} "User not found"
+// @Router /admin/users/{id}/disable [post]
+func (ac *AdminController) DisableUser(c *gin.Context) {
+	user, ok := ac.otherUser(c)
+	if !ok {
+		return
+	}
+
+	user, err := ac.UserService.SetDisabled(user.UserID, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ac.AuthorizationService.Invalidate(user.UserID)
+
+	if err := ac.TokenService.RevokeUser(user.UserID, services.RevokeReasonDisabled); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, ac.AuditService, services.AuditUserDisabled, &user.UserID, "")
+
+	c.JSON(http.StatusOK, gin.H{"user": ac.UserService.ToAdminResponse(user)})
+}
+
+// EnableUser godoc
+// @Summary Enable a user
+// @Description Re-enable a disabled user's account
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]interface{} "User enabled"
+// @Failure 400 {object} map[string]interface{} "Invalid user ID"
+// @Failure 403 {object} map[string]interface{} "Insufficient permissions"
+// @Failure 404 {object} map[string]interface{} "User not found"
+// @Router /admin/users/{id}/enable [post]
+func (ac *AdminController) EnableUser(c *gin.Context) {
+	user, ok := ac.otherUser(c)
+	if !ok {
+		return
+	}
+
+	user, err := ac.UserService.SetDisabled(user.UserID, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ac.AuthorizationService.Invalidate(user.UserID)
+
+	recordAudit(c, ac.AuditService, services.AuditUserEnabled, &user.UserID, "")
+
+	c.JSON(http.StatusOK, gin.H{"user": ac.UserService.ToAdminResponse(user)})
+}
+
+// UnlockUser godoc
+// @Summary Unlock a user
+// @Description Lift a lockout caused by too many failed logins and clear the failed login count
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]interface{} "User unlocked"
+// @Failure 400 {object} map[string]interface{} "Invalid user ID"
+// @Failure 403 {object} map[string]interface{} "Insufficient permissions"
+// @Failure 404 {object} map[string]interface{} "User not found"
+// @Router /admin/users/{id}/unlock [post]
+func (ac *AdminController) UnlockUser(c *gin.Context) {
+	user, ok := ac.targetUser(c)
+	if !ok {
+		return
+	}
+
+	user, err := ac.LoginProtection.Unlock(user.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, ac.AuditService, services.AuditUserUnlocked, &user.UserID, "")
+
+	c.JSON(http.StatusOK, gin.H{"user": ac.UserService.ToAdminResponse(user)})
+}
+
+// ForcePasswordReset godoc
+// @Summary Force a password reset
+// @Description Sign a user out everywhere, refuse their logins until they reset their password and email them a reset link
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]interface{} "Password reset required"
+// @Failure 400 {object} map[string]interface{} "Invalid user ID"
+// @Failure 403 {object} map[string]interface{} "Insufficient permissions"
+// @Failure 404 {object} map[string]interface{} "User not found"
+// @Router /admin/users/{id}/force-password-reset [post]
+func (ac *AdminController) ForcePasswordReset(c *gin.Context) {
+	user, ok := ac.otherUser(c)
+	if !ok {
+		return
+	}
+
+	user, err := ac.UserService.RequirePasswordReset(user.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ac.TokenService.RevokeUser(user.UserID, services.RevokeReasonPasswordReset); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ac.PasswordResetService.SendResetLink(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, ac.AuditService, services.AuditPasswordResetForced, &user.UserID, "")
+
+	c.JSON(http.StatusOK, gin.H{"user": ac.UserService.ToAdminResponse(user)})
+}
+
+// ForceLogout godoc
+// @Summary Force a logout
+// @Description Revoke every session of a user and close their live connections
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]interface{} "User logged out"
+// @Failure 400 {object} map[string]interface{} "Invalid user ID"
+// @Failure 403 {object} map[string]interface{} "Insufficient permissions"
+// @Failure 404 {object} map[string]interface{} "User not found"
+// @Router /admin/users/{id}/force-logout [post]
+func (ac *AdminController) ForceLogout(c *gin.Context) {
+	user, ok := ac.targetUser(c)
+	if !ok {
+		return
+	}
+
+	if err := ac.TokenService.RevokeUser(user.UserID, services.RevokeReasonForcedLogout); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ac.UserService.Logout(user.UserID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, ac.AuditService, services.AuditLogoutForced, &user.UserID, "")
+
+	c.JSON(http.StatusOK, gin.H{"message": "User logged out successfully"})
+}
+
+// GetAuditLogs godoc
+// @Summary List audit logs
+// @Description List audit log entries, newest first
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param user_id query int false "Target user filter"
+// @Param action query string false "Action filter"
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Page size (max 100)" default(20)
+// @Success 200 {object} map[string]interface{} "Audit logs"
+// @Failure 403 {object} map[string]interface{} "Insufficient permissions"
+// @Failure 500 {object} map[string]interface{} "Server error"
+// @Router /admin/audit-logs [get]
+func (ac *AdminController) GetAuditLogs(c *gin.Context) {
+	page, pageSize := pagination(c)
+	targetID, _ := strconv.ParseUint(c.Query("user_id"), 10, 32)
+
+	entries, total, err := ac.AuditService.List(uint(targetID), c.Query("action"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"audit_logs": entries,
+		"total":      total,
+		"page":       page,
+		"page_size":  pageSize,
+	})
+}
+
+// targetUser loads the user named by the id path parameter, writing an error response if it fails
+func (ac *AdminController) targetUser(c *gin.Context) (*models.User, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return nil, false
+	}
+
+	user, err := ac.UserService.GetUserByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return user, true
+}
+
+// otherUser loads the target user like targetUser, refusing an admin's own
+// account so they cannot lock themselves out
+func (ac *AdminController) otherUser(c *gin.Context) (*models.User, bool) {
+	user, ok := ac.targetUser(c)
+	if !ok {
+		return nil, false
+	}
+
+	if userID, _ := c.Get("user_id"); userID == user.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot change your own account"})
+		return nil, false
+	}
+	return user, true
+}
+
+// pagination reads the page and page_size query parameters
+func pagination(c *gin.Context) (int, int) {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	pageSize, err := strconv.Atoi(c.Query("page_size"))
+	if err != nil || pageSize < 1 {
+		pageSize = 20
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+
+	return page, pageSize
+}
@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/models"
+	"github.com/ginchat/services"
+	"github.com/sirupsen/logrus"
+)
+
+// recordAudit stores an audit log entry for a request. The actor is the
+// authenticated user, if any; failures are logged rather than failing the request.
+func recordAudit(c *gin.Context, audit *services.AuditService, action string, targetID *uint, details string) {
+	entry := &models.AuditLog{
+		TargetID:  targetID,
+		Action:    action,
+		Details:   details,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	if userID, exists := c.Get("user_id"); exists {
+		actorID := userID.(uint)
+		entry.ActorID = &actorID
+	}
+
+	if err := audit.Record(entry); err != nil {
+		logrus.Errorf("Failed to record audit log %s: %v", action, err)
+	}
+}
@@ -6,20 +6,20 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/ginchat/services"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 	"gorm.io/gorm"
 )
 
 // ChatroomController handles chatroom-related requests
 type ChatroomController struct {
 	ChatroomService *services.ChatroomService
+	MessageService  *services.MessageService
 }
 
 // NewChatroomController creates a new ChatroomController
-func NewChatroomController(db *gorm.DB, mongodb *mongo.Database) *ChatroomController {
-	chatroomService := services.NewChatroomService(mongodb)
+func NewChatroomController(db *gorm.DB, chatroomService *services.ChatroomService, messageService *services.MessageService) *ChatroomController {
 	return &ChatroomController{
 		ChatroomService: chatroomService,
+		MessageService:  messageService,
 	}
 }
 
@@ -64,7 +64,7 @@ func (cc *ChatroomController) CreateChatroom(c *gin.Context) {
 // GetChatrooms handles getting all chatrooms
 func (cc *ChatroomController) GetChatrooms(c *gin.Context) {
 	// Get user ID from context (set by auth middleware)
-	_, exists := c.Get("user_id")
+	userID, exists := c.Get("user_id")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
@@ -77,10 +77,25 @@ func (cc *ChatroomController) GetChatrooms(c *gin.Context) {
 		return
 	}
 
+	// Count unread messages in the chatrooms the user belongs to
+	var memberOf []primitive.ObjectID
+	for i := range chatrooms {
+		if cc.ChatroomService.IsMember(&chatrooms[i], userID.(uint)) {
+			memberOf = append(memberOf, chatrooms[i].ID)
+		}
+	}
+	unreadCounts, err := cc.MessageService.GetUnreadCounts(userID.(uint), memberOf)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Convert to response format
 	var response []interface{}
 	for _, chatroom := range chatrooms {
-		response = append(response, chatroom.ToResponse())
+		chatroomResponse := chatroom.ToResponse()
+		chatroomResponse.UnreadCount = unreadCounts[chatroom.ID]
+		response = append(response, chatroomResponse)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -0,0 +1,360 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/services"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EventStreamController streams the WebSocket hub's events over Server-Sent
+// Events, for clients whose network blocks WebSocket upgrades
+type EventStreamController struct {
+	Hub             *Hub
+	MessageService  *services.MessageService
+	ChatroomService *services.ChatroomService
+	PresenceService *services.PresenceService
+	TicketService   *services.StreamTicketService
+	Config          WebSocketConfig
+	logger          *logrus.Logger
+}
+
+// NewEventStreamController creates a new EventStreamController
+func NewEventStreamController(hub *Hub, messageService *services.MessageService, chatroomService *services.ChatroomService, presenceService *services.PresenceService, ticketService *services.StreamTicketService, logger *logrus.Logger) *EventStreamController {
+	return &EventStreamController{
+		Hub:             hub,
+		MessageService:  messageService,
+		ChatroomService: chatroomService,
+		PresenceService: presenceService,
+		TicketService:   ticketService,
+		Config:          LoadWebSocketConfig(),
+		logger:          logger,
+	}
+}
+
+// streamCursors holds the last message delivered in each chatroom of a stream.
+// They are carried in event IDs as comma-separated chatroomID:messageID pairs,
+// so a client reconnecting with Last-Event-ID resumes every chatroom from its own position.
+type streamCursors map[string]primitive.ObjectID
+
+// parseStreamCursors reads the cursors from an event ID
+func parseStreamCursors(eventID string) (streamCursors, error) {
+	cursors := make(streamCursors)
+	for _, pair := range strings.Split(eventID, ",") {
+		chatroomID, messageID, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, errors.New("invalid cursor")
+		}
+		if _, err := primitive.ObjectIDFromHex(chatroomID); err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		objectID, err := primitive.ObjectIDFromHex(messageID)
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		cursors[chatroomID] = objectID
+	}
+	return cursors, nil
+}
+
+// String encodes the cursors as an event ID
+func (sc streamCursors) String() string {
+	pairs := make([]string, 0, len(sc))
+	for chatroomID, messageID := range sc {
+		pairs = append(pairs, chatroomID+":"+messageID.Hex())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// IssueTicket godoc
+// @Summary Get an event stream ticket
+// @Description Get a short-lived, single-use ticket that authenticates GET /events through its ticket query parameter, for clients such as the browser EventSource API that cannot send an Authorization header
+// @Tags chat
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{} "Ticket"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Server error"
+// @Router /events/ticket [post]
+func (sc *EventStreamController) IssueTicket(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	tokenID, ok := c.Get("token_id")
+	tid, isString := tokenID.(string)
+	if !exists || !ok || !isString {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	ticket, expiresAt, err := sc.TicketService.Issue(userID.(uint), tid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ticket":     ticket,
+		"expires_at": expiresAt,
+	})
+}
+
+// Stream handles a Server-Sent Events connection.
+// The rooms query parameter lists the chatrooms to follow (all of the user's
+// chatrooms by default). Browsers authenticate with a ticket query parameter
+// from POST /events/ticket. Each event carries the same JSON envelope as the
+// WebSocket; the connected and new_message events carry the stream's
+// per-chatroom cursors as event ID, so a client reconnecting with
+// Last-Event-ID (or last_event_id) gets the messages it missed replayed first.
+func (sc *EventStreamController) Stream(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	uid := userID.(uint)
+
+	// Live connections are tied to the access token, so it can close them when revoked
+	tokenID, ok := c.Get("token_id")
+	tid, isString := tokenID.(string)
+	if !ok || !isString {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chatroomIDs, err := sc.streamRooms(c, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	resumed := make(streamCursors)
+	if lastEventID != "" {
+		resumed, err = parseStreamCursors(lastEventID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+			return
+		}
+	}
+
+	// Register the stream with the hub
+	client := NewStreamClient(uid, tid, sc.Config, sc.logger)
+	sc.Hub.Register(client)
+
+	// Mark the user online
+	if err := sc.PresenceService.Connect(uid); err != nil {
+		sc.logger.Warnf("Failed to update presence for user %d: %v", uid, err)
+	}
+
+	// Handle client disconnection
+	defer func() {
+		sc.Hub.Unregister(client)
+		client.Close(websocket.CloseNormalClosure, "")
+		if err := sc.PresenceService.Disconnect(uid); err != nil {
+			sc.logger.Warnf("Failed to update presence for user %d: %v", uid, err)
+		}
+	}()
+
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+	c.Writer.Header().Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	// Chatrooms without a cursor start from now, so messages sent while the
+	// client reconnects are replayed even if the chatroom was quiet until then
+	cursors := make(streamCursors, len(chatroomIDs))
+	start := primitive.NewObjectIDFromTimestamp(time.Now())
+	for _, chatroomID := range chatroomIDs {
+		cursors[chatroomID] = start
+		if cursor, ok := resumed[chatroomID]; ok {
+			cursors[chatroomID] = cursor
+		}
+	}
+
+	sc.writeEvent(c, cursors.String(), WebSocketMessage{
+		Type: "connected",
+		Data: map[string]interface{}{
+			"message": "Connected to event stream",
+			"user_id": uid,
+		},
+	})
+
+	// Subscribe to the chatrooms, replaying missed messages first when resuming
+	for _, chatroomID := range chatroomIDs {
+		cursor, ok := resumed[chatroomID]
+		if !ok {
+			if err := sc.Hub.Subscribe(client, chatroomID); err != nil {
+				sc.writeError(c, chatroomID, err)
+			}
+			continue
+		}
+		if err := sc.resume(c, client, chatroomID, cursor, cursors); err != nil {
+			sc.writeError(c, chatroomID, err)
+		}
+	}
+	c.Writer.Flush()
+
+	// Stream events until the client goes away or is evicted
+	keepAlive := time.NewTicker(sc.Config.PingInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case payload := <-client.Outbound():
+			sc.writePayload(c, payload, cursors)
+			c.Writer.Flush()
+
+		case <-keepAlive.C:
+			c.Writer.Write([]byte(": keep-alive\n\n"))
+			c.Writer.Flush()
+
+		case <-client.Done():
+			return
+
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// streamRooms returns the chatrooms requested in the rooms query parameter,
+// or every chatroom the user is a member of
+func (sc *EventStreamController) streamRooms(c *gin.Context, userID uint) ([]string, error) {
+	if rooms := c.Query("rooms"); rooms != "" {
+		var chatroomIDs []string
+		for _, chatroomID := range strings.Split(rooms, ",") {
+			if chatroomID = strings.TrimSpace(chatroomID); chatroomID != "" {
+				chatroomIDs = append(chatroomIDs, chatroomID)
+			}
+		}
+		return chatroomIDs, nil
+	}
+
+	chatrooms, err := sc.ChatroomService.GetChatroomsByMember(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	chatroomIDs := make([]string, 0, len(chatrooms))
+	for _, chatroom := range chatrooms {
+		chatroomIDs = append(chatroomIDs, chatroom.ID.Hex())
+	}
+	return chatroomIDs, nil
+}
+
+// resume subscribes to a chatroom and writes the messages newer than its cursor
+// before live delivery starts; a client too far behind is told to refetch over REST
+func (sc *EventStreamController) resume(c *gin.Context, client *Client, chatroomID string, lastMessageID primitive.ObjectID, cursors streamCursors) error {
+	objectID, err := primitive.ObjectIDFromHex(chatroomID)
+	if err != nil {
+		return err
+	}
+
+	if err := sc.Hub.SubscribeResuming(client, chatroomID); err != nil {
+		return err
+	}
+
+	messages, complete, err := sc.MessageService.GetMessagesSince(objectID, client.UserID, lastMessageID, sc.Config.ReplayLimit)
+	if err != nil {
+		sc.Hub.Unsubscribe(client, chatroomID)
+		return err
+	}
+
+	var lastReplayedID primitive.ObjectID
+	if complete {
+		for _, message := range messages {
+			cursors[chatroomID] = message.ID
+			sc.writeEvent(c, cursors.String(), WebSocketMessage{
+				Type:       "new_message",
+				ChatroomID: chatroomID,
+				Data:       message.ToResponse(),
+			})
+			lastReplayedID = message.ID
+		}
+	} else {
+		sc.writeEvent(c, "", WebSocketMessage{
+			Type:       "resync_required",
+			ChatroomID: chatroomID,
+			Data: map[string]interface{}{
+				"message": "Too many missed messages, refetch the chatroom history over REST",
+				"limit":   sc.Config.ReplayLimit,
+			},
+		})
+	}
+
+	sc.Hub.FinishResume(client, chatroomID, lastReplayedID)
+	return nil
+}
+
+// writePayload writes a message queued by the hub as an event named after its type
+func (sc *EventStreamController) writePayload(c *gin.Context, payload []byte, cursors streamCursors) {
+	var envelope struct {
+		Type       string          `json:"type"`
+		ChatroomID string          `json:"chatroom_id"`
+		Data       json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		sc.logger.Errorf("Failed to decode event: %v", err)
+		return
+	}
+
+	// Only stored messages can be resumed from, so only they move a cursor and carry an event ID
+	eventID := ""
+	if envelope.Type == "new_message" {
+		var message struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(envelope.Data, &message); err == nil {
+			if messageID, err := primitive.ObjectIDFromHex(message.ID); err == nil {
+				cursors[envelope.ChatroomID] = messageID
+				eventID = cursors.String()
+			}
+		}
+	}
+
+	sse.Encode(c.Writer, sse.Event{
+		Id:    eventID,
+		Event: envelope.Type,
+		Data:  string(payload),
+	})
+}
+
+// writeEvent encodes a message and writes it as an event
+func (sc *EventStreamController) writeEvent(c *gin.Context, eventID string, msg WebSocketMessage) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		sc.logger.Errorf("Failed to encode event: %v", err)
+		return
+	}
+
+	sse.Encode(c.Writer, sse.Event{
+		Id:    eventID,
+		Event: msg.Type,
+		Data:  string(payload),
+	})
+}
+
+// writeError writes an error event for a chatroom
+func (sc *EventStreamController) writeError(c *gin.Context, chatroomID string, err error) {
+	sc.writeEvent(c, "", WebSocketMessage{
+		Type:       "error",
+		ChatroomID: chatroomID,
+		Data: map[string]string{
+			"error": err.Error(),
+		},
+	})
+}
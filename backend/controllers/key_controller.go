@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/utils"
+)
+
+// KeyController publishes the public keys that verify GinChat tokens, so
+// other services can validate them without sharing a secret
+type KeyController struct{}
+
+// NewKeyController creates a new KeyController
+func NewKeyController() *KeyController {
+	return &KeyController{}
+}
+
+// JWKS serves the JSON Web Key Set at /.well-known/jwks.json.
+// It includes keys published ahead of signing and superseded keys still
+// within their retention period; the set
+// is empty when tokens are signed with the shared HS256 secret.
+func (kc *KeyController) JWKS(c *gin.Context) {
+	keys, err := utils.Keys()
+	if err != nil {
+		c.JSON(http.StatusOK, utils.JSONWebKeySet{Keys: []utils.JSONWebKey{}})
+		return
+	}
+
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(utils.JWKSMaxAge.Seconds())))
+	c.JSON(http.StatusOK, keys.JWKS())
+}
@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/services"
+)
+
+// respondLoginBlocked refuses an attempt from a locked account or a backed-off account or IP
+func respondLoginBlocked(c *gin.Context, block *services.LoginBlock) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(block.RetryAfter.Seconds()))))
+	if block.Locked {
+		c.JSON(http.StatusLocked, gin.H{"error": "Account is temporarily locked after too many failed logins"})
+	} else {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed logins, please try again later"})
+	}
+}
+
+// recordLoginFailure counts a failed credential check for an account, and
+// audits the lockout if this failure locked it
+func recordLoginFailure(c *gin.Context, protection *services.LoginProtectionService, audit *services.AuditService, email string) {
+	if locked := protection.RecordFailure(email, c.ClientIP()); locked != nil {
+		recordAudit(c, audit, services.AuditAccountLocked, &locked.UserID, fmt.Sprintf("locked for %s", protection.LockoutDuration))
+	}
+}
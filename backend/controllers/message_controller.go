@@ -3,33 +3,34 @@ package controllers
 import (
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ginchat/services"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 	"gorm.io/gorm"
 )
 
 // MessageController handles message-related requests
 type MessageController struct {
 	MessageService *services.MessageService
+	Hub            *Hub
 }
 
 // NewMessageController creates a new MessageController
-func NewMessageController(db *gorm.DB, mongodb *mongo.Database) *MessageController {
-	chatroomService := services.NewChatroomService(mongodb)
-	messageService := services.NewMessageService(mongodb, chatroomService)
+func NewMessageController(db *gorm.DB, messageService *services.MessageService, hub *Hub) *MessageController {
 	return &MessageController{
 		MessageService: messageService,
+		Hub:            hub,
 	}
 }
 
 // SendMessageRequest represents the request body for sending a message
 type SendMessageRequest struct {
-	MessageType string `json:"message_type" binding:"required,oneof=text picture audio video text_and_picture text_and_audio text_and_video"`
-	TextContent string `json:"text_content"`
-	MediaURL    string `json:"media_url"`
+	MessageType     string `json:"message_type" binding:"required,oneof=text picture audio video text_and_picture text_and_audio text_and_video"`
+	TextContent     string `json:"text_content"`
+	MediaURL        string `json:"media_url"`
+	ClientMessageID string `json:"client_message_id" binding:"omitempty,max=64"`
 }
 
 // SendMessage handles sending a message to a chatroom
@@ -56,24 +57,198 @@ func (mc *MessageController) SendMessage(c *gin.Context) {
 	username, _ := c.Get("username")
 
 	// Send message using the service
-	message, err := mc.MessageService.SendMessage(chatroomID, userID.(uint), username.(string), req.MessageType, req.TextContent, req.MediaURL)
+	message, created, err := mc.MessageService.SendMessage(chatroomID, userID.(uint), username.(string), req.MessageType, req.TextContent, req.MediaURL, req.ClientMessageID)
 	if err != nil {
 		if err.Error() == "chatroom not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		} else if err.Error() == "user is not a member of this chatroom" {
 			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		} else if strings.HasPrefix(err.Error(), "invalid message") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
 		return
 	}
 
+	// A retried message was already stored and delivered
+	if !created {
+		c.JSON(http.StatusOK, gin.H{
+			"message": message.ToResponse(),
+		})
+		return
+	}
+
+	// Push the stored message to the chatroom's WebSocket subscribers
+	mc.Hub.PublishMessage(message)
+
 	// Return message data
 	c.JSON(http.StatusCreated, gin.H{
 		"message": message.ToResponse(),
 	})
 }
 
+// MarkReadRequest represents the request body for marking messages as read
+type MarkReadRequest struct {
+	MessageID string `json:"message_id" binding:"required"`
+}
+
+// MarkRead handles marking a chatroom's messages as read up to a message
+func (mc *MessageController) MarkRead(c *gin.Context) {
+	var req MarkReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get chatroom ID from URL
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chatroom ID"})
+		return
+	}
+
+	messageID, err := primitive.ObjectIDFromHex(req.MessageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	username, _ := c.Get("username")
+
+	// Mark messages as read using the service
+	state, changed, err := mc.MessageService.MarkRead(chatroomID, userID.(uint), username.(string), messageID)
+	if err != nil {
+		if err.Error() == "chatroom not found" || err.Error() == "message not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else if err.Error() == "user is not a member of this chatroom" {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// Tell the chatroom's members how far the user has read
+	if changed {
+		mc.Hub.PublishReadReceipt(state)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"read_state": state.ToResponse(),
+	})
+}
+
+// EditMessageRequest represents the request body for editing a message
+type EditMessageRequest struct {
+	TextContent string `json:"text_content" binding:"required"`
+}
+
+// EditMessage handles replacing the text of a message. Users can edit their
+// own messages; editing other users' messages requires the chat:moderate permission.
+func (mc *MessageController) EditMessage(c *gin.Context) {
+	var req EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	chatroomID, messageID, ok := messageParams(c)
+	if !ok {
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	message, err := mc.MessageService.EditMessage(chatroomID, messageID, userID.(uint), req.TextContent, canModerate(c))
+	if err != nil {
+		respondMessageChangeError(c, err)
+		return
+	}
+
+	mc.Hub.PublishMessageChange("message_edited", userID.(uint), message)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": message.ToResponse(),
+	})
+}
+
+// DeleteMessage handles deleting a message. Users can delete their own
+// messages; deleting other users' messages requires the chat:moderate permission.
+func (mc *MessageController) DeleteMessage(c *gin.Context) {
+	chatroomID, messageID, ok := messageParams(c)
+	if !ok {
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	message, err := mc.MessageService.DeleteMessage(chatroomID, messageID, userID.(uint), canModerate(c))
+	if err != nil {
+		respondMessageChangeError(c, err)
+		return
+	}
+
+	mc.Hub.PublishMessageChange("message_deleted", userID.(uint), message)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Message deleted successfully"})
+}
+
+// messageParams reads the chatroom and message IDs from the URL, writing an error response if they are invalid
+func messageParams(c *gin.Context) (primitive.ObjectID, primitive.ObjectID, bool) {
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chatroom ID"})
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+
+	messageID, err := primitive.ObjectIDFromHex(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+
+	return chatroomID, messageID, true
+}
+
+// canModerate reports whether the user's current role, resolved by the
+// permission middleware, may change other users' messages
+func canModerate(c *gin.Context) bool {
+	role, _ := c.Get("role")
+	roleName, _ := role.(string)
+	return services.HasPermission(roleName, services.PermissionChatModerate)
+}
+
+// respondMessageChangeError writes the response for a failed edit or delete
+func respondMessageChangeError(c *gin.Context, err error) {
+	switch msg := err.Error(); {
+	case msg == "chatroom not found", msg == "message not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": msg})
+	case msg == "user is not a member of this chatroom", msg == "user is not the sender of this message":
+		c.JSON(http.StatusForbidden, gin.H{"error": msg})
+	case strings.HasPrefix(msg, "invalid message"):
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
 // GetMessages handles getting messages from a chatroom
 func (mc *MessageController) GetMessages(c *gin.Context) {
 	// Get chatroom ID from URL
@@ -113,13 +288,26 @@ func (mc *MessageController) GetMessages(c *gin.Context) {
 		return
 	}
 
+	// Get the read positions of the chatroom's members
+	readStates, err := mc.MessageService.GetReadStates(chatroomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Convert to response format
 	var response []interface{}
 	for _, message := range messages {
 		response = append(response, message.ToResponse())
 	}
 
+	var readStatesResponse []interface{}
+	for _, state := range readStates {
+		readStatesResponse = append(readStatesResponse, state.ToResponse())
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"messages": response,
+		"messages":    response,
+		"read_states": readStatesResponse,
 	})
 }
@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/services"
+	"gorm.io/gorm"
+)
+
+// oidcStateCookie binds a login started at a provider to the browser that started it
+const oidcStateCookie = "oidc_state"
+
+// OIDCController handles logins with external OpenID Connect providers
+type OIDCController struct {
+	UserService         *services.UserService
+	TokenService        *services.TokenService
+	OIDCService         *services.OIDCService
+	VerificationService *services.EmailVerificationService
+	TwoFactorService    *services.TwoFactorService
+	AuditService        *services.AuditService
+	LoginProtection     *services.LoginProtectionService
+}
+
+// NewOIDCController creates a new OIDCController
+func NewOIDCController(db *gorm.DB, userService *services.UserService, tokenService *services.TokenService, oidcService *services.OIDCService, emailVerificationService *services.EmailVerificationService, twoFactorService *services.TwoFactorService, auditService *services.AuditService, loginProtectionService *services.LoginProtectionService) *OIDCController {
+	return &OIDCController{
+		UserService:         userService,
+		TokenService:        tokenService,
+		OIDCService:         oidcService,
+		VerificationService: emailVerificationService,
+		TwoFactorService:    twoFactorService,
+		AuditService:        auditService,
+		LoginProtection:     loginProtectionService,
+	}
+}
+
+// OIDCExchangeRequest represents the request body for exchanging a completed external login
+type OIDCExchangeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// ListProviders godoc
+// @Summary List external login providers
+// @Description Get the OpenID Connect providers users can log in with
+// @Tags auth
+// @Produce json
+// @Success 200 {object} map[string]interface{} "Providers"
+// @Router /auth/oidc/providers [get]
+func (oc *OIDCController) ListProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": oc.OIDCService.ProviderList()})
+}
+
+// Login godoc
+// @Summary Start an external login
+// @Description Redirect the browser to the provider's login page
+// @Tags auth
+// @Param provider path string true "Provider name"
+// @Success 302 "Redirect to the provider"
+// @Failure 404 {object} map[string]interface{} "Unknown provider"
+// @Failure 502 {object} map[string]interface{} "Provider unavailable"
+// @Router /auth/oidc/{provider}/login [get]
+func (oc *OIDCController) Login(c *gin.Context) {
+	authURL, state, err := oc.OIDCService.StartLogin(c.Param("provider"))
+	if err != nil {
+		switch err.Error() {
+		case "unknown provider":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		case "provider unavailable":
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Login provider is unavailable"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// Lax lets the cookie through on the provider's top-level redirect back
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(oc.OIDCService.StateTTL.Seconds()), "/api/auth/oidc", "", secureCookies(), true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback godoc
+// @Summary Finish an external login
+// @Description Provider redirect target. Verifies the login, links or creates the user and redirects the browser to the frontend with a one-time login code.
+// @Tags auth
+// @Param provider path string true "Provider name"
+// @Param state query string true "Login state"
+// @Param code query string true "Authorization code"
+// @Success 303 "Redirect to the frontend"
+// @Router /auth/oidc/{provider}/callback [get]
+func (oc *OIDCController) Callback(c *gin.Context) {
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, "/api/auth/oidc", "", secureCookies(), true)
+
+	// The user denied the login or the provider failed
+	if c.Query("error") != "" {
+		oc.failLogin(c, "oidc_failed")
+		return
+	}
+
+	// A state from another browser means the login was not started here
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie)) != 1 {
+		oc.failLogin(c, "oidc_failed")
+		return
+	}
+
+	user, linked, err := oc.OIDCService.CompleteLogin(c.Param("provider"), state, c.Query("code"))
+	if err != nil {
+		if err.Error() == "email already registered" {
+			oc.failLogin(c, "oidc_email_conflict")
+			return
+		}
+		oc.failLogin(c, "oidc_failed")
+		return
+	}
+
+	if linked {
+		recordAudit(c, oc.AuditService, services.AuditIdentityLinked, &user.UserID, c.Param("provider"))
+	}
+
+	code, err := oc.OIDCService.IssueLoginCode(user)
+	if err != nil {
+		oc.failLogin(c, "oidc_failed")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, services.AppURL()+"/auth/oidc/callback?code="+url.QueryEscape(code))
+}
+
+// Exchange godoc
+// @Summary Complete an external login
+// @Description Exchange the one-time login code from the callback redirect for access and refresh tokens, or for a two-factor challenge
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body OIDCExchangeRequest true "Login code"
+// @Success 200 {object} map[string]interface{} "Login successful or two-factor authentication required"
+// @Failure 400 {object} map[string]interface{} "Invalid input"
+// @Failure 401 {object} map[string]interface{} "Invalid or expired login code"
+// @Failure 403 {object} map[string]interface{} "Account disabled or email not verified"
+// @Failure 423 {object} map[string]interface{} "Account locked after too many failed logins"
+// @Failure 429 {object} map[string]interface{} "Too many failed logins"
+// @Failure 500 {object} map[string]interface{} "Server error"
+// @Router /auth/oidc/exchange [post]
+func (oc *OIDCController) Exchange(c *gin.Context) {
+	var req OIDCExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := oc.OIDCService.RedeemLoginCode(req.Code)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login code is invalid or has expired, please log in again"})
+		return
+	}
+
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+	if oc.VerificationService.BlocksLogin(user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email not verified"})
+		return
+	}
+
+	// An external login replaces the password, not the second factor
+	if user.TwoFactorEnabled {
+		// Accounts locked or backed off after wrong codes get no new challenge
+		if block := oc.LoginProtection.Check(user.Email, c.ClientIP()); block != nil {
+			respondLoginBlocked(c, block)
+			return
+		}
+
+		challenge, expiresAt, err := oc.TwoFactorService.Challenge(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start two-factor login"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+			"expires_at":          expiresAt,
+		})
+		return
+	}
+
+	oc.UserService.MarkLoggedIn(user)
+
+	// Issue access and refresh tokens
+	tokens, err := oc.TokenService.IssueTokens(user, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	logUserActivity(c, user.UserID, "User logged in with an external provider")
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":          oc.UserService.ToResponse(user),
+		"token":         tokens.AccessToken,
+		"expires_at":    tokens.AccessExpiresAt,
+		"refresh_token": tokens.RefreshToken,
+	})
+}
+
+// failLogin sends the browser back to the login page with a reason
+func (oc *OIDCController) failLogin(c *gin.Context, reason string) {
+	c.Redirect(http.StatusSeeOther, services.AppURL()+"/auth/login?session="+reason)
+}
+
+// secureCookies reports whether cookies should be limited to HTTPS
+func secureCookies() bool {
+	return strings.HasPrefix(services.AppURL(), "https://")
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/services"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// PasswordController handles forgotten password requests
+type PasswordController struct {
+	PasswordResetService *services.PasswordResetService
+	AuditService         *services.AuditService
+	PasswordPolicy       *services.PasswordPolicy
+	MailThrottle         *services.MailThrottle
+}
+
+// NewPasswordController creates a new PasswordController
+func NewPasswordController(db *gorm.DB, passwordResetService *services.PasswordResetService, auditService *services.AuditService, passwordPolicy *services.PasswordPolicy, mailThrottle *services.MailThrottle) *PasswordController {
+	return &PasswordController{
+		PasswordResetService: passwordResetService,
+		AuditService:         auditService,
+		PasswordPolicy:       passwordPolicy,
+		MailThrottle:         mailThrottle,
+	}
+}
+
+// ForgotPasswordRequest represents the request body for requesting a password reset
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest represents the request body for resetting a password
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// ForgotPassword godoc
+// @Summary Request a password reset
+// @Description Email a single-use password reset link. The response is the same whether or not the email is registered. Requests are rate limited per account and per IP address.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body ForgotPasswordRequest true "Account email"
+// @Success 200 {object} map[string]interface{} "Reset link sent if the account exists"
+// @Failure 400 {object} map[string]interface{} "Invalid input"
+// @Failure 429 {object} map[string]interface{} "Too many requests from this IP address"
+// @Router /auth/password/forgot [post]
+func (pc *PasswordController) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if wait := pc.MailThrottle.Allow(c.ClientIP()); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many password reset requests, please try again later"})
+		return
+	}
+
+	// Send the email in the background so the response time does not reveal whether the account exists
+	requestContext := c.Copy()
+	go func() {
+		user, err := pc.PasswordResetService.RequestReset(req.Email)
+		if err != nil && err.Error() == "too many reset emails" {
+			// Answer as usual, so the limit does not reveal whether the account exists
+			logrus.Warnf("Password reset email to user %d not sent: requested too often", user.UserID)
+			return
+		}
+		if err != nil {
+			logrus.Errorf("Failed to send password reset email: %v", err)
+			return
+		}
+		if user != nil {
+			recordAudit(requestContext, pc.AuditService, services.AuditPasswordResetRequested, &user.UserID, "")
+		}
+	}()
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an account exists for this email, a password reset link has been sent"})
+}
+
+// ResetPassword godoc
+// @Summary Reset a password
+// @Description Set a new password with a reset token. Every session of the account is signed out.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body ResetPasswordRequest true "Reset token and new password"
+// @Success 200 {object} map[string]interface{} "Password reset"
+// @Failure 400 {object} map[string]interface{} "Invalid input, weak password or invalid token"
+// @Failure 403 {object} map[string]interface{} "Account disabled"
+// @Failure 500 {object} map[string]interface{} "Server error"
+// @Router /auth/password/reset [post]
+func (pc *PasswordController) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the password against the password policy for the token's account
+	user, err := pc.PasswordResetService.UserForToken(req.Token)
+	if err != nil {
+		if err.Error() == "invalid or expired token" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	if err := pc.PasswordPolicy.Validate(req.Password, user.Username, user.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err = pc.PasswordResetService.ResetPassword(req.Token, req.Password)
+	if err != nil {
+		switch err.Error() {
+		case "invalid or expired token":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		case "account disabled":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	logUserActivity(c, user.UserID, "Password reset")
+	recordAudit(c, pc.AuditService, services.AuditPasswordReset, &user.UserID, "")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset, please log in with your new password"})
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/models"
+	"github.com/ginchat/services"
+	"gorm.io/gorm"
+)
+
+// SessionController handles requests for a user's login sessions
+type SessionController struct {
+	TokenService *services.TokenService
+}
+
+// NewSessionController creates a new SessionController
+func NewSessionController(db *gorm.DB, tokenService *services.TokenService) *SessionController {
+	return &SessionController{
+		TokenService: tokenService,
+	}
+}
+
+// GetSessions godoc
+// @Summary List active sessions
+// @Description List the devices the current user is logged in on
+// @Tags auth
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{} "Active sessions"
+// @Failure 401 {object} map[string]interface{} "User not authenticated"
+// @Failure 500 {object} map[string]interface{} "Server error"
+// @Router /auth/sessions [get]
+func (sc *SessionController) GetSessions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	sessions, err := sc.TokenService.ListSessions(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Flag the session this request was made from
+	tokenID, _ := c.Get("token_id")
+	tid, _ := tokenID.(string)
+	currentID, _ := sc.TokenService.SessionIDForToken(tid)
+
+	responses := make([]models.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		responses = append(responses, session.ToResponse(session.ID == currentID))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": responses})
+}
+
+// RevokeSession godoc
+// @Summary Revoke a session
+// @Description Sign out one of the current user's sessions. Its tokens stop working immediately and its live connections are closed.
+// @Tags auth
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Session ID"
+// @Success 200 {object} map[string]interface{} "Session revoked"
+// @Failure 401 {object} map[string]interface{} "User not authenticated"
+// @Failure 404 {object} map[string]interface{} "Session not found"
+// @Failure 500 {object} map[string]interface{} "Server error"
+// @Router /auth/sessions/{id} [delete]
+func (sc *SessionController) RevokeSession(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	if err := sc.TokenService.RevokeSession(userID.(uint), c.Param("id")); err != nil {
+		if err.Error() == "session not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	logUserActivity(c, userID.(uint), "Session revoked")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
@@ -0,0 +1,334 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/models"
+	"github.com/ginchat/services"
+	"gorm.io/gorm"
+)
+
+// TwoFactorController handles TOTP two-factor authentication requests
+type TwoFactorController struct {
+	UserService      *services.UserService
+	TokenService     *services.TokenService
+	TwoFactorService *services.TwoFactorService
+	AuditService     *services.AuditService
+	LoginProtection  *services.LoginProtectionService
+}
+
+// NewTwoFactorController creates a new TwoFactorController
+func NewTwoFactorController(db *gorm.DB, userService *services.UserService, tokenService *services.TokenService, twoFactorService *services.TwoFactorService, auditService *services.AuditService, loginProtectionService *services.LoginProtectionService) *TwoFactorController {
+	return &TwoFactorController{
+		UserService:      userService,
+		TokenService:     tokenService,
+		TwoFactorService: twoFactorService,
+		AuditService:     auditService,
+		LoginProtection:  loginProtectionService,
+	}
+}
+
+// TwoFactorCodeRequest represents a request body carrying a TOTP or recovery code
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// DisableTwoFactorRequest represents the request body for turning two-factor authentication off
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// TwoFactorLoginRequest represents the request body for the second login step
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// GetStatus godoc
+// @Summary Get two-factor authentication status
+// @Description Report whether two-factor authentication is enabled and how many recovery codes are left
+// @Tags auth
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{} "Two-factor status"
+// @Failure 401 {object} map[string]interface{} "User not authenticated"
+// @Router /auth/2fa [get]
+func (tc *TwoFactorController) GetStatus(c *gin.Context) {
+	user, ok := tc.currentUser(c)
+	if !ok {
+		return
+	}
+
+	remaining, err := tc.TwoFactorService.RemainingRecoveryCodes(user.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  user.TwoFactorEnabled,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// Setup godoc
+// @Summary Start two-factor enrolment
+// @Description Generate a TOTP secret and its otpauth URI for an authenticator app. Two-factor authentication is enabled once a code is confirmed.
+// @Tags auth
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{} "Secret and otpauth URI"
+// @Failure 401 {object} map[string]interface{} "User not authenticated"
+// @Failure 409 {object} map[string]interface{} "Two-factor authentication already enabled"
+// @Router /auth/2fa/setup [post]
+func (tc *TwoFactorController) Setup(c *gin.Context) {
+	user, ok := tc.currentUser(c)
+	if !ok {
+		return
+	}
+
+	secret, uri, err := tc.TwoFactorService.Setup(user)
+	if err != nil {
+		if err.Error() == "two-factor authentication already enabled" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": uri,
+	})
+}
+
+// Confirm godoc
+// @Summary Confirm two-factor enrolment
+// @Description Enable two-factor authentication with a first code from the authenticator app. The recovery codes are returned once and cannot be retrieved later.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body TwoFactorCodeRequest true "TOTP code"
+// @Success 200 {object} map[string]interface{} "Two-factor enabled, with recovery codes"
+// @Failure 400 {object} map[string]interface{} "Invalid code or enrolment not started"
+// @Failure 409 {object} map[string]interface{} "Two-factor authentication already enabled"
+// @Router /auth/2fa/confirm [post]
+func (tc *TwoFactorController) Confirm(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := tc.currentUser(c)
+	if !ok {
+		return
+	}
+
+	codes, err := tc.TwoFactorService.Confirm(user, req.Code)
+	if err != nil {
+		switch err.Error() {
+		case "two-factor authentication already enabled":
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		case "two-factor authentication not set up":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor enrolment has not been started"})
+		case "invalid code":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	recordAudit(c, tc.AuditService, services.AuditTwoFactorEnabled, &user.UserID, "")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// Disable godoc
+// @Summary Disable two-factor authentication
+// @Description Turn two-factor authentication off. Requires the password and a TOTP or recovery code.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body DisableTwoFactorRequest true "Password and code"
+// @Success 200 {object} map[string]interface{} "Two-factor disabled"
+// @Failure 400 {object} map[string]interface{} "Invalid code or two-factor not enabled"
+// @Failure 401 {object} map[string]interface{} "Invalid password"
+// @Router /auth/2fa/disable [post]
+func (tc *TwoFactorController) Disable(c *gin.Context) {
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := tc.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if !tc.UserService.VerifyPassword(user.Password, req.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
+	}
+
+	if err := tc.TwoFactorService.Disable(user, req.Code); err != nil {
+		switch err.Error() {
+		case "two-factor authentication not enabled":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		case "invalid code":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	recordAudit(c, tc.AuditService, services.AuditTwoFactorDisabled, &user.UserID, "")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Regenerate recovery codes
+// @Description Replace the recovery codes with a new set after checking a TOTP code. The old codes stop working.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body TwoFactorCodeRequest true "TOTP code"
+// @Success 200 {object} map[string]interface{} "New recovery codes"
+// @Failure 400 {object} map[string]interface{} "Invalid code or two-factor not enabled"
+// @Router /auth/2fa/recovery-codes [post]
+func (tc *TwoFactorController) RegenerateRecoveryCodes(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := tc.currentUser(c)
+	if !ok {
+		return
+	}
+
+	codes, err := tc.TwoFactorService.RegenerateRecoveryCodes(user, req.Code)
+	if err != nil {
+		switch err.Error() {
+		case "two-factor authentication not enabled":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		case "invalid code":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	recordAudit(c, tc.AuditService, services.AuditRecoveryCodesReset, &user.UserID, "")
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// VerifyLogin godoc
+// @Summary Complete a two-factor login
+// @Description Exchange the challenge token returned by login and a TOTP or recovery code for access and refresh tokens
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body TwoFactorLoginRequest true "Challenge token and code"
+// @Success 200 {object} map[string]interface{} "Login successful"
+// @Failure 400 {object} map[string]interface{} "Invalid input"
+// @Failure 401 {object} map[string]interface{} "Invalid code or invalid or expired challenge"
+// @Failure 403 {object} map[string]interface{} "Account disabled"
+// @Failure 423 {object} map[string]interface{} "Account locked after too many failed logins"
+// @Failure 429 {object} map[string]interface{} "Too many failed logins"
+// @Router /auth/login/2fa [post]
+func (tc *TwoFactorController) VerifyLogin(c *gin.Context) {
+	var req TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	challenged, err := tc.TwoFactorService.ChallengeUser(req.ChallengeToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login challenge is invalid or has expired, please log in again"})
+		return
+	}
+
+	// Wrong codes count as failed logins, so guessing them backs off and locks the account like guessing passwords
+	if block := tc.LoginProtection.Check(challenged.Email, c.ClientIP()); block != nil {
+		respondLoginBlocked(c, block)
+		return
+	}
+
+	user, recovery, err := tc.TwoFactorService.CompleteChallenge(req.ChallengeToken, req.Code)
+	if err != nil {
+		switch err.Error() {
+		case "invalid or expired challenge":
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Login challenge is invalid or has expired, please log in again"})
+		case "invalid code":
+			recordLoginFailure(c, tc.LoginProtection, tc.AuditService, challenged.Email)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// The account may have been disabled since the password step
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+
+	tc.LoginProtection.RecordSuccess(user)
+
+	if recovery {
+		recordAudit(c, tc.AuditService, services.AuditRecoveryCodeUsed, &user.UserID, "")
+	}
+
+	tc.UserService.MarkLoggedIn(user)
+
+	// Issue access and refresh tokens
+	tokens, err := tc.TokenService.IssueTokens(user, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	logUserActivity(c, user.UserID, "User logged in with two-factor authentication")
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":          tc.UserService.ToResponse(user),
+		"token":         tokens.AccessToken,
+		"expires_at":    tokens.AccessExpiresAt,
+		"refresh_token": tokens.RefreshToken,
+	})
+}
+
+// currentUser loads the authenticated user, writing an error response if that fails
+func (tc *TwoFactorController) currentUser(c *gin.Context) (*models.User, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, false
+	}
+
+	user, err := tc.UserService.GetUserByID(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return user, true
+}
@@ -1,29 +1,37 @@
 package controllers
 
 import (
-	"fmt"
 	"math/rand"
 	"net/http"
-	"regexp"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ginchat/services"
-	"github.com/ginchat/utils"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
 // UserController handles user-related requests
 type UserController struct {
-	UserService *services.UserService
+	UserService         *services.UserService
+	TokenService        *services.TokenService
+	VerificationService *services.EmailVerificationService
+	TwoFactorService    *services.TwoFactorService
+	LoginProtection     *services.LoginProtectionService
+	AuditService        *services.AuditService
+	PasswordPolicy      *services.PasswordPolicy
 }
 
 // NewUserController creates a new UserController
-func NewUserController(db *gorm.DB, userService *services.UserService) *UserController {
+func NewUserController(db *gorm.DB, userService *services.UserService, tokenService *services.TokenService, verificationService *services.EmailVerificationService, twoFactorService *services.TwoFactorService, loginProtection *services.LoginProtectionService, auditService *services.AuditService, passwordPolicy *services.PasswordPolicy) *UserController {
 	return &UserController{
-		UserService: userService,
+		UserService:         userService,
+		TokenService:        tokenService,
+		VerificationService: verificationService,
+		TwoFactorService:    twoFactorService,
+		LoginProtection:     loginProtection,
+		AuditService:        auditService,
+		PasswordPolicy:      passwordPolicy,
 	}
 }
 
@@ -31,7 +39,7 @@ func NewUserController(db *gorm.DB, userService *services.UserService) *UserCont
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=50"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
 }
 
 // LoginRequest represents the request body for user login
@@ -40,9 +48,19 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// RefreshRequest represents the request body for renewing an access token
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// LogoutRequest represents the optional request body for logout
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 // Register godoc
 // @Summary Register a new user
-// @Description Register a new user with username, email and password
+// @Description Register a new user with username, email and password. A verification link is emailed; when the verification policy blocks login, no tokens are returned until the email is verified.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -59,14 +77,14 @@ func (uc *UserController) Register(c *gin.Context) {
 		return
 	}
 
-	// Validate password strength
-	if err := validatePasswordStrength(req.Password); err != nil {
+	// Validate the password against the password policy
+	if err := uc.PasswordPolicy.Validate(req.Password, req.Username, req.Email); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Register user using the user service
-	user, err := uc.UserService.Register(req.Username, req.Email, req.Password, "member")
+	user, err := uc.UserService.Register(req.Username, req.Email, req.Password, services.RoleMember)
 	if err != nil {
 		if err.Error() == "user with this email or username already exists" {
 			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
@@ -76,33 +94,53 @@ func (uc *UserController) Register(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := utils.GenerateJWT(user.UserID, user.Username, user.Email, user.Role)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
-		return
+	// Email a verification link; the account exists either way, so a failure is only logged
+	if err := uc.VerificationService.SendVerification(user); err != nil {
+		logrus.Errorf("Failed to send verification email to user %d: %v", user.UserID, err)
 	}
 
 	// Log the registration
 	logUserActivity(c, user.UserID, "User registered")
 
-	// Return user data and token
+	// Users who cannot log in before verifying get no tokens yet
+	if uc.VerificationService.BlocksLogin(user) {
+		c.JSON(http.StatusCreated, gin.H{
+			"user":                  uc.UserService.ToResponse(user),
+			"verification_required": true,
+			"message":               "Please verify your email address before logging in",
+		})
+		return
+	}
+
+	// Issue access and refresh tokens
+	tokens, err := uc.TokenService.IssueTokens(user, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	// Return user data and tokens
 	c.JSON(http.StatusCreated, gin.H{
-		"user":  uc.UserService.ToResponse(user),
-		"token": token,
+		"user":          uc.UserService.ToResponse(user),
+		"token":         tokens.AccessToken,
+		"expires_at":    tokens.AccessExpiresAt,
+		"refresh_token": tokens.RefreshToken,
 	})
 }
 
 // Login godoc
 // @Summary Login a user
-// @Description Login with email and password to get authentication token
+// @Description Login with email and password to get authentication token. Accounts with two-factor authentication get a challenge token instead, to be completed at /auth/login/2fa.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param user body LoginRequest true "User Login Data"
-// @Success 200 {object} map[string]interface{} "Login successful"
+// @Success 200 {object} map[string]interface{} "Login successful or two-factor code required"
 // @Failure 400 {object} map[string]interface{} "Invalid input"
 // @Failure 401 {object} map[string]interface{} "Invalid credentials"
+// @Failure 403 {object} map[string]interface{} "Account disabled, password reset required or email not verified"
+// @Failure 423 {object} map[string]interface{} "Account locked after too many failed logins"
+// @Failure 429 {object} map[string]interface{} "Too many failed logins, retry later"
 // @Failure 500 {object} map[string]interface{} "Server error"
 // @Router /auth/login [post]
 func (uc *UserController) Login(c *gin.Context) {
@@ -112,39 +150,136 @@ func (uc *UserController) Login(c *gin.Context) {
 		return
 	}
 
-	// Login user using the user service
-	user, err := uc.UserService.Login(req.Email, req.Password)
+	// Refuse attempts from locked accounts and backed-off accounts or IPs before checking the password
+	if block := uc.LoginProtection.Check(req.Email, c.ClientIP()); block != nil {
+		respondLoginBlocked(c, block)
+		return
+	}
+
+	// Check the credentials using the user service
+	user, err := uc.UserService.Authenticate(req.Email, req.Password)
 	if err != nil {
+		switch err.Error() {
+		case "account disabled":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+			return
+		case "password reset required":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Password reset required"})
+			return
+		}
+
+		recordLoginFailure(c, uc.LoginProtection, uc.AuditService, req.Email)
+
 		// Add a small delay to prevent timing attacks
 		time.Sleep(time.Duration(100+rand.Intn(100)) * time.Millisecond)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
 	}
 
-	// Generate JWT token
-	token, err := utils.GenerateJWT(user.UserID, user.Username, user.Email, user.Role)
+	if uc.VerificationService.BlocksLogin(user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email not verified"})
+		return
+	}
+
+	// Tokens are only issued once the second factor is verified; failed logins
+	// are only cleared then, so knowing the password does not reset the backoff
+	if user.TwoFactorEnabled {
+		challenge, expiresAt, err := uc.TwoFactorService.Challenge(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start two-factor login"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+			"expires_at":          expiresAt,
+		})
+		return
+	}
+
+	uc.UserService.MarkLoggedIn(user)
+
+	// Issue access and refresh tokens
+	tokens, err := uc.TokenService.IssueTokens(user, c.ClientIP(), c.Request.UserAgent())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
+	uc.LoginProtection.RecordSuccess(user)
+
 	// Log the login
 	logUserActivity(c, user.UserID, "User logged in")
 
-	// Return user data and token
+	// Return user data and tokens
+	c.JSON(http.StatusOK, gin.H{
+		"user":          uc.UserService.ToResponse(user),
+		"token":         tokens.AccessToken,
+		"expires_at":    tokens.AccessExpiresAt,
+		"refresh_token": tokens.RefreshToken,
+	})
+}
+
+// Refresh godoc
+// @Summary Refresh an access token
+// @Description Exchange a refresh token for a new access token and a new refresh token. Each refresh token can be used once; reusing one revokes every token issued from the same login.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param token body RefreshRequest true "Refresh Token"
+// @Success 200 {object} map[string]interface{} "Tokens refreshed"
+// @Failure 400 {object} map[string]interface{} "Invalid input"
+// @Failure 401 {object} map[string]interface{} "Invalid, expired, revoked or reused refresh token"
+// @Failure 403 {object} map[string]interface{} "Account disabled or password reset required"
+// @Failure 500 {object} map[string]interface{} "Server error"
+// @Router /auth/refresh [post]
+func (uc *UserController) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, user, err := uc.TokenService.Refresh(req.RefreshToken, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		switch err.Error() {
+		case "invalid refresh token", "refresh token revoked", "refresh token expired", "user not found":
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case "account disabled":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		case "password reset required":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Password reset required"})
+		case "refresh token reuse detected":
+			logrus.WithFields(logrus.Fields{
+				"ip_address": c.ClientIP(),
+				"user_agent": c.Request.UserAgent(),
+			}).Warn("Refresh token reuse detected, token family revoked")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// Log the refresh
+	logUserActivity(c, user.UserID, "Access token refreshed")
+
 	c.JSON(http.StatusOK, gin.H{
-		"user":  uc.UserService.ToResponse(user),
-		"token": token,
+		"token":         tokens.AccessToken,
+		"expires_at":    tokens.AccessExpiresAt,
+		"refresh_token": tokens.RefreshToken,
 	})
 }
 
 // Logout godoc
 // @Summary Logout a user
-// @Description Logout the currently authenticated user
+// @Description Logout the currently authenticated user, revoking the access token immediately and, when sent, the refresh token of the login
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
+// @Param token body LogoutRequest false "Refresh token to revoke"
 // @Success 200 {object} map[string]interface{} "Logout successful"
 // @Failure 401 {object} map[string]interface{} "User not authenticated"
 // @Failure 404 {object} map[string]interface{} "User not found"
@@ -163,6 +298,32 @@ func (uc *UserController) Logout(c *gin.Context) {
 		return
 	}
 
+	// Revoke the access token used for this request
+	tokenID, _ := c.Get("token_id")
+	expiresAt, _ := c.Get("token_expires_at")
+	tid, ok := tokenID.(string)
+	expiry, hasExpiry := expiresAt.(time.Time)
+	if !ok || !hasExpiry {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	if err := uc.TokenService.RevokeAccessToken(userIDUint, tid, expiry, services.RevokeReasonLogout); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// End the session of this login, found from the refresh token if one was sent
+	var req LogoutRequest
+	if c.ShouldBindJSON(&req) == nil && req.RefreshToken != "" {
+		if err := uc.TokenService.RevokeRefreshToken(userIDUint, req.RefreshToken, services.RevokeReasonLogout); err != nil {
+			logrus.Warnf("Failed to revoke refresh token for user %d: %v", userIDUint, err)
+		}
+	} else if sessionID, err := uc.TokenService.SessionIDForToken(tid); err == nil {
+		if err := uc.TokenService.RevokeFamily(sessionID, services.RevokeReasonLogout); err != nil {
+			logrus.Warnf("Failed to end session for user %d: %v", userIDUint, err)
+		}
+	}
+
 	// Logout user using the user service
 	err := uc.UserService.Logout(userIDUint)
 	if err != nil {
@@ -177,44 +338,6 @@ func (uc *UserController) Logout(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
-// validatePasswordStrength checks if a password meets the minimum security requirements
-func validatePasswordStrength(password string) error {
-	if len(password) < 8 {
-		return fmt.Errorf("password must be at least 8 characters long")
-	}
-
-	// Check for at least one uppercase letter
-	if !regexp.MustCompile(`[A-Z]`).MatchString(password) {
-		return fmt.Errorf("password must contain at least one uppercase letter")
-	}
-
-	// Check for at least one lowercase letter
-	if !regexp.MustCompile(`[a-z]`).MatchString(password) {
-		return fmt.Errorf("password must contain at least one lowercase letter")
-	}
-
-	// Check for at least one digit
-	if !regexp.MustCompile(`[0-9]`).MatchString(password) {
-		return fmt.Errorf("password must contain at least one digit")
-	}
-
-	// Check for at least one special character
-	if !regexp.MustCompile(`[^a-zA-Z0-9]`).MatchString(password) {
-		return fmt.Errorf("password must contain at least one special character")
-	}
-
-	// Check for common passwords
-	commonPasswords := []string{"password", "123456", "qwerty", "admin", "welcome"}
-	passwordLower := strings.ToLower(password)
-	for _, common := range commonPasswords {
-		if strings.Contains(passwordLower, common) {
-			return fmt.Errorf("password contains a common pattern that is easily guessable")
-		}
-	}
-
-	return nil
-}
-
 // logUserActivity logs user activities for auditing purposes
 func logUserActivity(c *gin.Context, userID uint, activity string) {
 	// Get client IP
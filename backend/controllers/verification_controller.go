@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/services"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// VerificationController handles email verification requests
+type VerificationController struct {
+	VerificationService *services.EmailVerificationService
+	AuditService        *services.AuditService
+	MailThrottle        *services.MailThrottle
+}
+
+// NewVerificationController creates a new VerificationController
+func NewVerificationController(db *gorm.DB, verificationService *services.EmailVerificationService, auditService *services.AuditService, mailThrottle *services.MailThrottle) *VerificationController {
+	return &VerificationController{
+		VerificationService: verificationService,
+		AuditService:        auditService,
+		MailThrottle:        mailThrottle,
+	}
+}
+
+// ResendVerificationRequest represents the request body for resending a verification email
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// VerifyEmail godoc
+// @Summary Verify an email address
+// @Description Verify the email address a verification link was sent to. Browsers are redirected to the login page.
+// @Tags auth
+// @Produce json
+// @Param token query string true "Verification token"
+// @Success 200 {object} map[string]interface{} "Email verified"
+// @Failure 400 {object} map[string]interface{} "Invalid or expired token"
+// @Router /auth/verify [get]
+func (vc *VerificationController) VerifyEmail(c *gin.Context) {
+	// Links opened from an email land on the frontend rather than on raw JSON
+	browser := strings.Contains(c.GetHeader("Accept"), "text/html")
+
+	user, err := vc.VerificationService.Verify(c.Query("token"))
+	if err != nil {
+		if browser {
+			c.Redirect(http.StatusSeeOther, services.AppURL()+"/auth/login?session=verify_failed")
+			return
+		}
+		if err.Error() == "invalid or expired token" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	recordAudit(c, vc.AuditService, services.AuditEmailVerified, &user.UserID, user.Email)
+
+	if browser {
+		c.Redirect(http.StatusSeeOther, services.AppURL()+"/auth/login?session=verified")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerification godoc
+// @Summary Resend the verification email
+// @Description Email a new verification link to an unverified account. The response is the same whether or not the email needs verifying. Requests are rate limited per account and per IP address.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body ResendVerificationRequest true "Account email"
+// @Success 200 {object} map[string]interface{} "Verification email sent if the account needs one"
+// @Failure 400 {object} map[string]interface{} "Invalid input"
+// @Failure 429 {object} map[string]interface{} "Too many requests from this IP address"
+// @Router /auth/verify/resend [post]
+func (vc *VerificationController) ResendVerification(c *gin.Context) {
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if wait := vc.MailThrottle.Allow(c.ClientIP()); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many verification emails, please try again later"})
+		return
+	}
+
+	// Send the email in the background so the response time does not reveal whether the account exists
+	go func() {
+		user, err := vc.VerificationService.Resend(req.Email)
+		if err != nil && err.Error() == "too many verification emails" {
+			// Answer as usual, so the limit does not reveal whether the account exists
+			logrus.Warnf("Verification email to user %d not sent: requested too often", user.UserID)
+			return
+		}
+		if err != nil {
+			logrus.Errorf("Failed to send verification email: %v", err)
+		}
+	}()
+
+	c.JSON(http.StatusOK, gin.H{"message": "If this account needs verifying, a verification email has been sent"})
+}
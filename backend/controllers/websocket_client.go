@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ginchat/utils"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// WebSocketConfig holds the connection settings applied to every WebSocket client
+type WebSocketConfig struct {
+	// PingInterval is the interval at which pings are sent to the peer
+	PingInterval time.Duration
+	// PongWait is the time allowed to read the next pong (or any message) from the peer
+	PongWait time.Duration
+	// WriteWait is the time allowed to write a message to the peer
+	WriteWait time.Duration
+	// MaxMessageSize is the maximum size in bytes of a message read from the peer
+	MaxMessageSize int64
+	// SendBufferSize is the number of outbound messages queued per client before it is evicted
+	SendBufferSize int
+	// ReplayLimit is the maximum number of missed messages replayed when a client resumes a chatroom
+	ReplayLimit int
+}
+
+// LoadWebSocketConfig reads the WebSocket settings from the environment
+func LoadWebSocketConfig() WebSocketConfig {
+	config := WebSocketConfig{
+		PingInterval:   utils.GetEnvDuration("WS_PING_INTERVAL", 30*time.Second),
+		PongWait:       utils.GetEnvDuration("WS_PONG_WAIT", 60*time.Second),
+		WriteWait:      utils.GetEnvDuration("WS_WRITE_WAIT", 10*time.Second),
+		MaxMessageSize: int64(utils.GetEnvInt("WS_MAX_MESSAGE_SIZE", 64*1024)),
+		SendBufferSize: utils.GetEnvInt("WS_SEND_BUFFER_SIZE", 256),
+		ReplayLimit:    utils.GetEnvInt("WS_REPLAY_LIMIT", 100),
+	}
+
+	// Pings must be sent before the peer's read deadline expires
+	if config.PingInterval >= config.PongWait {
+		config.PingInterval = config.PongWait * 9 / 10
+	}
+
+	return config
+}
+
+// Client is a WebSocket connection with its own outbound queue.
+// All writes to the connection happen on the client's writer goroutine.
+type Client struct {
+	UserID    uint
+	TokenID   string // jti of the access token the client authenticated with
+	conn      *websocket.Conn
+	send      chan []byte
+	done      chan struct{}
+	stopped   chan struct{} // Closed once the writer goroutine has exited
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+	config    WebSocketConfig
+	logger    *logrus.Logger
+}
+
+// NewClient creates a new Client, applies the read limits and deadlines to its
+// connection and starts its writer goroutine
+func NewClient(userID uint, tokenID string, conn *websocket.Conn, config WebSocketConfig, logger *logrus.Logger) *Client {
+	client := &Client{
+		UserID:  userID,
+		TokenID: tokenID,
+		conn:    conn,
+		send:    make(chan []byte, config.SendBufferSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		config:  config,
+		logger:  logger,
+	}
+
+	// A peer that stops answering pings hits the read deadline and is disconnected
+	conn.SetReadLimit(config.MaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(config.PongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(config.PongWait))
+	})
+
+	go client.writePump()
+
+	return client
+}
+
+// NewStreamClient creates a Client without a WebSocket connection. Its queued
+// messages are consumed by the caller through Outbound, e.g. to stream them
+// as Server-Sent Events.
+func NewStreamClient(userID uint, tokenID string, config WebSocketConfig, logger *logrus.Logger) *Client {
+	return &Client{
+		UserID:  userID,
+		TokenID: tokenID,
+		send:    make(chan []byte, config.SendBufferSize),
+		done:    make(chan struct{}),
+		config:  config,
+		logger:  logger,
+	}
+}
+
+// Outbound returns the queue of messages waiting to be written to the client
+func (c *Client) Outbound() <-chan []byte {
+	return c.send
+}
+
+// Send queues a payload for delivery. A client whose queue is full is
+// disconnected, so a slow reader never blocks the sender.
+func (c *Client) Send(payload []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- payload:
+		return true
+	default:
+		c.logger.WithFields(logrus.Fields{
+			"user_id":    c.UserID,
+			"queue_size": c.config.SendBufferSize,
+		}).Warn("WebSocket send queue overflow, disconnecting slow client")
+		c.Close(websocket.CloseTryAgainLater, "send queue overflow")
+		return false
+	}
+}
+
+// SendMessage encodes and queues a WebSocketMessage
+func (c *Client) SendMessage(msg WebSocketMessage) bool {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		c.logger.Errorf("Failed to encode message: %v", err)
+		return false
+	}
+	return c.Send(payload)
+}
+
+// Close stops the writer goroutine, which sends a close frame with the given code and closes the connection
+func (c *Client) Close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.done)
+	})
+}
+
+// Done returns a channel that is closed once the client has been closed
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// writePump writes queued messages and pings to the connection.
+// It exits, closing the connection, as soon as the client is closed.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(c.config.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+		close(c.stopped)
+	}()
+
+	for {
+		select {
+		case payload := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				c.logger.Warnf("Failed to send message: %v", err)
+				c.Close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
+				c.logger.Warnf("Failed to ping client: %v", err)
+				c.Close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+
+		case <-c.done:
+			if c.closeCode != websocket.CloseAbnormalClosure {
+				closeMsg := websocket.FormatCloseMessage(c.closeCode, c.closeText)
+				c.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(c.config.WriteWait))
+			}
+			return
+		}
+	}
+}
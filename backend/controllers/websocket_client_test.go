@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ginchat/services"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// testConnection is a server-side WebSocket connection served the way
+// HandleConnection serves it; done is closed once its cleanup has run
+type testConnection struct {
+	client chan *Client
+	done   chan struct{}
+}
+
+func newTestServer(t *testing.T, hub *Hub, config WebSocketConfig) (*httptest.Server, *testConnection) {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	wsc := &WebSocketController{Hub: hub, Config: config, logger: logger}
+
+	conn := &testConnection{
+		client: make(chan *Client, 1),
+		done:   make(chan struct{}),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+
+		client := NewClient(1, "test-token", ws, config, logger)
+		conn.client <- client
+		wsc.serveClient(client, "tester", func() { close(conn.done) })
+	}))
+	t.Cleanup(server.Close)
+
+	return server, conn
+}
+
+func testConfig() WebSocketConfig {
+	return WebSocketConfig{
+		PingInterval:   50 * time.Millisecond,
+		PongWait:       time.Second,
+		WriteWait:      time.Second,
+		MaxMessageSize: 1024,
+		SendBufferSize: 8,
+		ReplayLimit:    10,
+	}
+}
+
+func dial(t *testing.T, server *httptest.Server) *websocket.Conn {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	return ws
+}
+
+// waitFor fails the test unless ch is closed within the timeout
+func waitFor(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+// assertReleased fails the test if the hub still holds the client
+func assertReleased(t *testing.T, hub *Hub, client *Client) {
+	t.Helper()
+
+	hub.mux.RLock()
+	defer hub.mux.RUnlock()
+	if hub.clients[client.UserID][client] {
+		t.Error("hub still holds the client")
+	}
+	if _, ok := hub.subscribed[client]; ok {
+		t.Error("hub still holds the client's subscriptions")
+	}
+}
+
+func TestClientDisconnectStopsGoroutines(t *testing.T) {
+	hub := NewHub(nil, services.NewMemoryBroker(), logrus.New())
+	server, conn := newTestServer(t, hub, testConfig())
+
+	ws := dial(t, server)
+	client := <-conn.client
+
+	ws.Close()
+
+	waitFor(t, conn.done, "read loop to return")
+	waitFor(t, client.stopped, "writer goroutine to exit")
+	assertReleased(t, hub, client)
+}
+
+func TestServerCloseStopsGoroutines(t *testing.T) {
+	hub := NewHub(nil, services.NewMemoryBroker(), logrus.New())
+	server, conn := newTestServer(t, hub, testConfig())
+
+	ws := dial(t, server)
+	defer ws.Close()
+	client := <-conn.client
+
+	// Evicting a client closes the connection, which ends the read loop
+	client.Close(websocket.CloseTryAgainLater, "send queue overflow")
+
+	// Skip the connected message to reach the close frame
+	ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var err error
+	for err == nil {
+		_, _, err = ws.ReadMessage()
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseTryAgainLater {
+		t.Errorf("expected close code %d, got %v", websocket.CloseTryAgainLater, err)
+	}
+
+	waitFor(t, conn.done, "read loop to return")
+	waitFor(t, client.stopped, "writer goroutine to exit")
+	assertReleased(t, hub, client)
+}
+
+func TestMissedPongsDisconnect(t *testing.T) {
+	config := testConfig()
+	config.PongWait = 200 * time.Millisecond
+	config.PingInterval = 100 * time.Millisecond
+
+	hub := NewHub(nil, services.NewMemoryBroker(), logrus.New())
+	server, conn := newTestServer(t, hub, config)
+
+	// A peer that never reads never answers pings
+	ws := dial(t, server)
+	defer ws.Close()
+	client := <-conn.client
+
+	waitFor(t, conn.done, "read deadline to end the read loop")
+	waitFor(t, client.stopped, "writer goroutine to exit")
+	assertReleased(t, hub, client)
+}
@@ -2,39 +2,45 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ginchat/models"
+	"github.com/ginchat/services"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // WebSocketController handles WebSocket connections
 type WebSocketController struct {
-	clients    map[uint]map[*websocket.Conn]bool
-	clientsMux sync.RWMutex
-	broadcast  chan []byte
-	logger     *logrus.Logger
+	Hub                 *Hub
+	MessageService      *services.MessageService
+	PresenceService     *services.PresenceService
+	TypingService       *services.TypingService
+	VerificationService *services.EmailVerificationService
+	Config              WebSocketConfig
+	logger              *logrus.Logger
 }
 
 // NewWebSocketController creates a new WebSocketController
-func NewWebSocketController(logger *logrus.Logger) *WebSocketController {
-	controller := &WebSocketController{
-		clients:   make(map[uint]map[*websocket.Conn]bool),
-		broadcast: make(chan []byte),
-		logger:    logger,
+func NewWebSocketController(hub *Hub, messageService *services.MessageService, presenceService *services.PresenceService, typingService *services.TypingService, verificationService *services.EmailVerificationService, logger *logrus.Logger) *WebSocketController {
+	return &WebSocketController{
+		Hub:                 hub,
+		MessageService:      messageService,
+		PresenceService:     presenceService,
+		TypingService:       typingService,
+		VerificationService: verificationService,
+		Config:              LoadWebSocketConfig(),
+		logger:              logger,
 	}
-
-	// Start broadcast handler
-	go controller.handleBroadcasts()
-
-	return controller
 }
 
 // WebSocketMessage represents a message sent over WebSocket
 type WebSocketMessage struct {
+	ID         string      `json:"id,omitempty"` // Client-generated ID, echoed in acks and errors
 	Type       string      `json:"type"`
 	ChatroomID string      `json:"chatroom_id,omitempty"`
 	Data       interface{} `json:"data"`
@@ -59,6 +65,14 @@ func (wsc *WebSocketController) HandleConnection(c *gin.Context) {
 	}
 	uid := userID.(uint)
 
+	// Live connections are tied to the access token, so it can close them when revoked
+	tokenID, ok := c.Get("token_id")
+	tid, isString := tokenID.(string)
+	if !ok || !isString {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
 	// Upgrade HTTP connection to WebSocket
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -66,42 +80,53 @@ func (wsc *WebSocketController) HandleConnection(c *gin.Context) {
 		return
 	}
 
-	// Register client
-	wsc.clientsMux.Lock()
-	if _, ok := wsc.clients[uid]; !ok {
-		wsc.clients[uid] = make(map[*websocket.Conn]bool)
+	// Mark the user online
+	if err := wsc.PresenceService.Connect(uid); err != nil {
+		wsc.logger.Warnf("Failed to update presence for user %d: %v", uid, err)
 	}
-	wsc.clients[uid][conn] = true
-	wsc.clientsMux.Unlock()
+
+	username, _ := c.Get("username")
+	client := NewClient(uid, tid, conn, wsc.Config, wsc.logger)
+	wsc.serveClient(client, username.(string), func() {
+		if err := wsc.PresenceService.Disconnect(uid); err != nil {
+			wsc.logger.Warnf("Failed to update presence for user %d: %v", uid, err)
+		}
+		// Typing indicators come from this instance's connections; another open tab may still be typing
+		if wsc.PresenceService.LocalConnections(uid) == 0 {
+			wsc.TypingService.StopAll(uid)
+		}
+	})
+}
+
+// serveClient registers a client with the hub and handles its messages until
+// the connection ends, then releases the client and runs onClose
+func (wsc *WebSocketController) serveClient(client *Client, username string, onClose func()) {
+	wsc.Hub.Register(client)
+
+	// Handle client disconnection
+	defer func() {
+		wsc.Hub.Unregister(client)
+		client.Close(websocket.CloseNormalClosure, "")
+		onClose()
+	}()
 
 	// Send connection success message
-	connectMsg := WebSocketMessage{
+	client.SendMessage(WebSocketMessage{
 		Type: "connected",
 		Data: map[string]interface{}{
 			"message": "Connected to WebSocket server",
-			"user_id": uid,
+			"user_id": client.UserID,
 		},
-	}
-	connectJSON, _ := json.Marshal(connectMsg)
-	conn.WriteMessage(websocket.TextMessage, connectJSON)
+	})
 
-	// Handle client disconnection
-	defer func() {
-		wsc.clientsMux.Lock()
-		delete(wsc.clients[uid], conn)
-		if len(wsc.clients[uid]) == 0 {
-			delete(wsc.clients, uid)
-		}
-		wsc.clientsMux.Unlock()
-		conn.Close()
-	}()
-
-	// Start ping-pong to keep connection alive
-	go wsc.pingClient(conn, uid)
+	wsc.readPump(client, client.UserID, username)
+}
 
-	// Handle incoming messages
+// readPump reads and handles the client's messages until the connection
+// fails, is closed by the peer or misses its read deadline
+func (wsc *WebSocketController) readPump(client *Client, uid uint, username string) {
 	for {
-		_, message, err := conn.ReadMessage()
+		_, message, err := client.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				wsc.logger.Errorf("WebSocket error: %v", err)
@@ -116,23 +141,78 @@ func (wsc *WebSocketController) HandleConnection(c *gin.Context) {
 			continue
 		}
 
+		// Anything other than a heartbeat counts as user activity
+		if msg.Type != "heartbeat" {
+			wsc.PresenceService.Touch(uid)
+		}
+
 		// Handle different message types
 		switch msg.Type {
 		case "heartbeat":
 			// Update user's heartbeat timestamp
-			// This would typically update the user's status in the database
-			heartbeatMsg := WebSocketMessage{
+			if err := wsc.PresenceService.Heartbeat(uid); err != nil {
+				wsc.logger.Warnf("Failed to update heartbeat for user %d: %v", uid, err)
+			}
+			client.SendMessage(WebSocketMessage{
 				Type: "heartbeat_ack",
 				Data: map[string]string{
 					"timestamp": time.Now().Format(time.RFC3339),
 				},
+			})
+
+		case "subscribe":
+			if err := wsc.subscribe(client, msg); err != nil {
+				sendError(client, msg, err)
 			}
-			heartbeatJSON, _ := json.Marshal(heartbeatMsg)
-			conn.WriteMessage(websocket.TextMessage, heartbeatJSON)
+
+		case "unsubscribe":
+			wsc.Hub.Unsubscribe(client, msg.ChatroomID)
+			client.SendMessage(WebSocketMessage{Type: "unsubscribed", ChatroomID: msg.ChatroomID})
 
 		case "chat_message":
-			// Broadcast the message to all clients
-			wsc.broadcast <- message
+			// Store the message and broadcast it to the members of its chatroom
+			message, created, err := wsc.sendChatMessage(uid, username, msg)
+			if err != nil {
+				sendError(client, msg, err)
+				continue
+			}
+
+			// Acknowledge with the stored message; a retry is acknowledged but not re-broadcast
+			client.SendMessage(WebSocketMessage{
+				ID:         msg.ID,
+				Type:       "message_ack",
+				ChatroomID: msg.ChatroomID,
+				Data: map[string]interface{}{
+					"message_id": message.ID.Hex(),
+					"sent_at":    message.SentAt,
+					"duplicate":  !created,
+				},
+			})
+			if created {
+				wsc.Hub.PublishMessage(message)
+			}
+			wsc.TypingService.StopTyping(msg.ChatroomID, uid)
+
+		case "read":
+			// Move the user's read position and tell the chatroom's members
+			state, changed, err := wsc.markRead(uid, username, msg)
+			if err != nil {
+				sendError(client, msg, err)
+				continue
+			}
+			if changed {
+				wsc.Hub.PublishReadReceipt(state)
+			}
+
+		case "typing_start":
+			if !wsc.Hub.IsSubscribed(client, msg.ChatroomID) {
+				sendError(client, msg, errors.New("not subscribed to this chatroom"))
+				continue
+			}
+			wsc.TypingService.StartTyping(msg.ChatroomID, uid, username)
+
+		case "typing_stop":
+			wsc.TypingService.StopTyping(msg.ChatroomID, uid)
 
 		default:
 			wsc.logger.Warnf("Unknown message type: %s", msg.Type)
@@ -140,44 +220,138 @@ func (wsc *WebSocketController) HandleConnection(c *gin.Context) {
 	}
 }
 
-// pingClient sends periodic pings to keep the connection alive
-func (wsc *WebSocketController) pingClient(conn *websocket.Conn, _ uint) {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+// SubscribeRequest represents the data of a subscribe message
+type SubscribeRequest struct {
+	LastMessageID string `json:"last_message_id"` // Last message seen, to replay anything missed since
+}
 
-	for {
-		select {
-		case <-ticker.C:
-			if err := conn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
-				wsc.logger.Warnf("Failed to ping client: %v", err)
-				return
-			}
+// subscribe subscribes a client to a chatroom. If the client passes the last
+// message it has seen, newer messages are replayed before live delivery starts;
+// a client too far behind is told to refetch over REST instead.
+func (wsc *WebSocketController) subscribe(client *Client, msg WebSocketMessage) error {
+	var req SubscribeRequest
+	if msg.Data != nil {
+		if err := decodeData(msg.Data, &req); err != nil {
+			return errors.New("invalid subscribe: malformed data")
 		}
 	}
-}
-
-// handleBroadcasts processes messages from the broadcast channel
-func (wsc *WebSocketController) handleBroadcasts() {
-	for {
-		message := <-wsc.broadcast
 
-		// Parse the message to determine which chatroom it's for
-		var msg WebSocketMessage
-		if err := json.Unmarshal(message, &msg); err != nil {
-			wsc.logger.Errorf("Failed to unmarshal broadcast message: %v", err)
-			continue
+	if req.LastMessageID == "" {
+		if err := wsc.Hub.Subscribe(client, msg.ChatroomID); err != nil {
+			return err
 		}
+		client.SendMessage(WebSocketMessage{ID: msg.ID, Type: "subscribed", ChatroomID: msg.ChatroomID})
+		return nil
+	}
 
-		// Send the message to all connected clients
-		wsc.clientsMux.RLock()
-		for _, clients := range wsc.clients {
-			for client := range clients {
-				if err := client.WriteMessage(websocket.TextMessage, message); err != nil {
-					wsc.logger.Errorf("Failed to send message: %v", err)
-					client.Close()
-				}
-			}
+	lastMessageID, err := primitive.ObjectIDFromHex(req.LastMessageID)
+	if err != nil {
+		return errors.New("invalid message ID")
+	}
+	chatroomID, err := primitive.ObjectIDFromHex(msg.ChatroomID)
+	if err != nil {
+		return errors.New("invalid chatroom ID")
+	}
+
+	// Hold back live messages while the missed ones are loaded
+	if err := wsc.Hub.SubscribeResuming(client, msg.ChatroomID); err != nil {
+		return err
+	}
+
+	messages, complete, err := wsc.MessageService.GetMessagesSince(chatroomID, client.UserID, lastMessageID, wsc.Config.ReplayLimit)
+	if err != nil {
+		wsc.Hub.Unsubscribe(client, msg.ChatroomID)
+		return err
+	}
+
+	var lastReplayedID primitive.ObjectID
+	if complete {
+		replayed := make([]models.MessageResponse, 0, len(messages))
+		for _, message := range messages {
+			replayed = append(replayed, message.ToResponse())
+		}
+		if len(messages) > 0 {
+			lastReplayedID = messages[len(messages)-1].ID
 		}
-		wsc.clientsMux.RUnlock()
+		client.SendMessage(WebSocketMessage{
+			Type:       "replay",
+			ChatroomID: msg.ChatroomID,
+			Data: map[string]interface{}{
+				"messages": replayed,
+			},
+		})
+	} else {
+		client.SendMessage(WebSocketMessage{
+			Type:       "resync_required",
+			ChatroomID: msg.ChatroomID,
+			Data: map[string]interface{}{
+				"message": "Too many missed messages, refetch the chatroom history over REST",
+				"limit":   wsc.Config.ReplayLimit,
+			},
+		})
+	}
+
+	client.SendMessage(WebSocketMessage{ID: msg.ID, Type: "subscribed", ChatroomID: msg.ChatroomID})
+	wsc.Hub.FinishResume(client, msg.ChatroomID, lastReplayedID)
+	return nil
+}
+
+// sendChatMessage stores a chat_message received over WebSocket through the message service.
+// The envelope ID is the client message ID used to deduplicate retries.
+func (wsc *WebSocketController) sendChatMessage(userID uint, username string, msg WebSocketMessage) (*models.Message, bool, error) {
+	chatroomID, err := primitive.ObjectIDFromHex(msg.ChatroomID)
+	if err != nil {
+		return nil, false, errors.New("invalid chatroom ID")
 	}
+
+	var req SendMessageRequest
+	if err := decodeData(msg.Data, &req); err != nil {
+		return nil, false, errors.New("invalid message: malformed data")
+	}
+
+	if allowed, err := wsc.VerificationService.CanSendMessages(userID); err != nil || !allowed {
+		return nil, false, errors.New("email not verified")
+	}
+
+	return wsc.MessageService.SendMessage(chatroomID, userID, username, req.MessageType, req.TextContent, req.MediaURL, msg.ID)
+}
+
+// markRead records a read message received over WebSocket through the message service
+func (wsc *WebSocketController) markRead(userID uint, username string, msg WebSocketMessage) (*models.ReadState, bool, error) {
+	chatroomID, err := primitive.ObjectIDFromHex(msg.ChatroomID)
+	if err != nil {
+		return nil, false, errors.New("invalid chatroom ID")
+	}
+
+	var req MarkReadRequest
+	if err := decodeData(msg.Data, &req); err != nil {
+		return nil, false, errors.New("invalid read: malformed data")
+	}
+	messageID, err := primitive.ObjectIDFromHex(req.MessageID)
+	if err != nil {
+		return nil, false, errors.New("invalid message ID")
+	}
+
+	return wsc.MessageService.MarkRead(chatroomID, userID, username, messageID)
+}
+
+// decodeData decodes the data of a WebSocketMessage into a typed struct
+func decodeData(data interface{}, v interface{}) error {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(dataJSON, v)
+}
+
+// sendError queues an error reply to a message for a single client
+func sendError(client *Client, msg WebSocketMessage, err error) {
+	client.SendMessage(WebSocketMessage{
+		ID:         msg.ID,
+		Type:       "error",
+		ChatroomID: msg.ChatroomID,
+		Data: map[string]string{
+			"error": err.Error(),
+		},
+	})
 }
@@ -0,0 +1,428 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/ginchat/models"
+	"github.com/ginchat/services"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// hubMessage is a serialized WebSocketMessage addressed either to the
+// subscribers of a chatroom or to every connection of a set of users.
+// It is the envelope published on the broker.
+type hubMessage struct {
+	ChatroomID    string          `json:"chatroom_id,omitempty"`
+	MessageID     string          `json:"message_id,omitempty"`
+	Members       map[uint]bool   `json:"members,omitempty"`
+	ExcludeID     uint            `json:"exclude_id,omitempty"`
+	UserIDs       []uint          `json:"user_ids,omitempty"`
+	CloseTokenIDs []string        `json:"close_token_ids,omitempty"` // Close the users' clients authenticated with these tokens
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// subscription is a client's subscription to a chatroom. While a client is
+// resuming, live messages for the chatroom are held back until the missed
+// messages have been replayed.
+type subscription struct {
+	resuming bool
+	pending  []hubMessage
+}
+
+// Hub tracks WebSocket clients and the chatrooms each client is subscribed to.
+// Messages are published on the broker and delivered to the local clients of
+// every hub subscribed to the chatroom or user topic.
+type Hub struct {
+	clients    map[uint]map[*Client]bool
+	rooms      map[string]map[*Client]bool
+	subscribed map[*Client]map[string]*subscription
+	topics     map[string]func()
+	mux        sync.RWMutex
+	broadcast  chan hubMessage
+	ChatSvc    *services.ChatroomService
+	Broker     services.Broker
+	logger     *logrus.Logger
+}
+
+// NewHub creates a new Hub
+func NewHub(chatroomService *services.ChatroomService, broker services.Broker, logger *logrus.Logger) *Hub {
+	hub := &Hub{
+		clients:    make(map[uint]map[*Client]bool),
+		rooms:      make(map[string]map[*Client]bool),
+		subscribed: make(map[*Client]map[string]*subscription),
+		topics:     make(map[string]func()),
+		broadcast:  make(chan hubMessage),
+		ChatSvc:    chatroomService,
+		Broker:     broker,
+		logger:     logger,
+	}
+
+	// Start broadcast handler
+	go hub.handleBroadcasts()
+
+	return hub
+}
+
+// Register adds a client for its user
+func (h *Hub) Register(client *Client) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if _, ok := h.clients[client.UserID]; !ok {
+		h.clients[client.UserID] = make(map[*Client]bool)
+		h.subscribeTopic(services.UserTopic(client.UserID))
+	}
+	h.clients[client.UserID][client] = true
+	h.subscribed[client] = make(map[string]*subscription)
+}
+
+// Unregister removes a client and all of its room subscriptions
+func (h *Hub) Unregister(client *Client) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	for chatroomID := range h.subscribed[client] {
+		h.removeFromRoom(chatroomID, client)
+	}
+	delete(h.subscribed, client)
+
+	delete(h.clients[client.UserID], client)
+	if len(h.clients[client.UserID]) == 0 {
+		delete(h.clients, client.UserID)
+		h.unsubscribeTopic(services.UserTopic(client.UserID))
+	}
+}
+
+// Subscribe subscribes a client to a chatroom its user is a member of
+func (h *Hub) Subscribe(client *Client, chatroomID string) error {
+	return h.subscribe(client, chatroomID, false)
+}
+
+// SubscribeResuming subscribes a client to a chatroom but holds back live
+// messages until FinishResume is called, so missed messages can be replayed first
+func (h *Hub) SubscribeResuming(client *Client, chatroomID string) error {
+	return h.subscribe(client, chatroomID, true)
+}
+
+// FinishResume delivers the live messages held back while a client was resuming,
+// skipping messages already covered by the replay, and switches to live delivery.
+// lastReplayedID is the zero ObjectID when nothing was replayed.
+func (h *Hub) FinishResume(client *Client, chatroomID string, lastReplayedID primitive.ObjectID) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	sub, ok := h.subscribed[client][chatroomID]
+	if !ok || !sub.resuming {
+		return
+	}
+
+	for _, msg := range sub.pending {
+		if msg.MessageID != "" && !lastReplayedID.IsZero() {
+			// ObjectIDs order by creation time, then by their remaining bytes
+			messageID, err := primitive.ObjectIDFromHex(msg.MessageID)
+			if err == nil && bytes.Compare(messageID[:], lastReplayedID[:]) <= 0 {
+				continue
+			}
+		}
+		client.Send(msg.Payload)
+	}
+	sub.pending = nil
+	sub.resuming = false
+}
+
+// subscribe checks membership and adds a client to a chatroom
+func (h *Hub) subscribe(client *Client, chatroomID string, resuming bool) error {
+	if _, err := h.checkMembership(client.UserID, chatroomID); err != nil {
+		return err
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if _, ok := h.subscribed[client]; !ok {
+		return errors.New("client is not registered")
+	}
+	if _, ok := h.rooms[chatroomID]; !ok {
+		h.rooms[chatroomID] = make(map[*Client]bool)
+		h.subscribeTopic(services.RoomTopic(chatroomID))
+	}
+	h.rooms[chatroomID][client] = true
+	h.subscribed[client][chatroomID] = &subscription{resuming: resuming}
+
+	return nil
+}
+
+// Unsubscribe removes a client from a chatroom
+func (h *Hub) Unsubscribe(client *Client, chatroomID string) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.removeFromRoom(chatroomID, client)
+	delete(h.subscribed[client], chatroomID)
+}
+
+// IsSubscribed reports whether a client is subscribed to a chatroom
+func (h *Hub) IsSubscribed(client *Client, chatroomID string) bool {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	_, ok := h.subscribed[client][chatroomID]
+	return ok
+}
+
+// Publish delivers a message to the members of its chatroom subscribed on this hub.
+// The sender must be a member of the chatroom.
+func (h *Hub) Publish(senderID uint, msg WebSocketMessage) error {
+	return h.publish(senderID, msg, false, "")
+}
+
+// PublishToOthers delivers a message to the members of its chatroom other than the sender
+func (h *Hub) PublishToOthers(senderID uint, msg WebSocketMessage) error {
+	return h.publish(senderID, msg, true, "")
+}
+
+// publish checks the sender's membership and queues a message for the chatroom's subscribers
+func (h *Hub) publish(senderID uint, msg WebSocketMessage, excludeSender bool, messageID string) error {
+	members, err := h.checkMembership(senderID, msg.ChatroomID)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return errors.New("failed to encode message")
+	}
+
+	var excludeID uint
+	if excludeSender {
+		excludeID = senderID
+	}
+
+	return h.publishEnvelope(services.RoomTopic(msg.ChatroomID), hubMessage{
+		ChatroomID: msg.ChatroomID,
+		MessageID:  messageID,
+		Members:    members,
+		ExcludeID:  excludeID,
+		Payload:    payload,
+	})
+}
+
+// PublishMessage delivers a stored message to its chatroom as a new_message event
+func (h *Hub) PublishMessage(message *models.Message) {
+	msg := WebSocketMessage{
+		Type:       "new_message",
+		ChatroomID: message.ChatroomID.Hex(),
+		Data:       message.ToResponse(),
+	}
+	if err := h.publish(message.SenderID, msg, false, message.ID.Hex()); err != nil {
+		h.logger.Errorf("Failed to publish message %s: %v", message.ID.Hex(), err)
+	}
+}
+
+// PublishMessageChange delivers a message edited or deleted by userID to the subscribers of its chatroom
+func (h *Hub) PublishMessageChange(eventType string, userID uint, message *models.Message) {
+	msg := WebSocketMessage{
+		Type:       eventType,
+		ChatroomID: message.ChatroomID.Hex(),
+		Data:       message.ToResponse(),
+	}
+	if err := h.publish(userID, msg, false, ""); err != nil {
+		h.logger.Errorf("Failed to publish %s for message %s: %v", eventType, message.ID.Hex(), err)
+	}
+}
+
+// SendToUsers delivers a message to every connection of the given users
+func (h *Hub) SendToUsers(userIDs []uint, msg WebSocketMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return errors.New("failed to encode message")
+	}
+
+	for _, userID := range userIDs {
+		envelope := hubMessage{
+			UserIDs: []uint{userID},
+			Payload: payload,
+		}
+		if err := h.publishEnvelope(services.UserTopic(userID), envelope); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PublishPresence delivers a presence change to the users who share a chatroom with its user
+func (h *Hub) PublishPresence(event services.PresenceEvent) {
+	msg := WebSocketMessage{
+		Type: "presence_changed",
+		Data: event,
+	}
+	if err := h.SendToUsers(event.Recipients, msg); err != nil {
+		h.logger.Errorf("Failed to publish presence for user %d: %v", event.UserID, err)
+	}
+}
+
+// PublishReadReceipt tells the members of a chatroom how far a user has read
+func (h *Hub) PublishReadReceipt(state *models.ReadState) {
+	msg := WebSocketMessage{
+		Type:       "read_receipt",
+		ChatroomID: state.ChatroomID.Hex(),
+		Data:       state.ToResponse(),
+	}
+	if err := h.Publish(state.UserID, msg); err != nil {
+		h.logger.Errorf("Failed to publish read receipt for user %d: %v", state.UserID, err)
+	}
+}
+
+// PublishTyping relays a typing indicator to the other members of its chatroom
+func (h *Hub) PublishTyping(event services.TypingEvent) {
+	msgType := "typing_stop"
+	if event.Typing {
+		msgType = "typing_start"
+	}
+
+	msg := WebSocketMessage{
+		Type:       msgType,
+		ChatroomID: event.ChatroomID,
+		Data:       event,
+	}
+	if err := h.PublishToOthers(event.UserID, msg); err != nil {
+		h.logger.Errorf("Failed to publish typing indicator for user %d: %v", event.UserID, err)
+	}
+}
+
+// CloseRevoked closes the connections, on every instance, that authenticated with revoked access tokens
+func (h *Hub) CloseRevoked(event services.RevocationEvent) {
+	envelope := hubMessage{
+		UserIDs:       []uint{event.UserID},
+		CloseTokenIDs: event.TokenIDs,
+	}
+	if err := h.publishEnvelope(services.UserTopic(event.UserID), envelope); err != nil {
+		h.logger.Errorf("Failed to publish token revocation for user %d: %v", event.UserID, err)
+	}
+}
+
+// publishEnvelope publishes a hubMessage on a broker topic
+func (h *Hub) publishEnvelope(topic string, msg hubMessage) error {
+	envelope, err := json.Marshal(msg)
+	if err != nil {
+		return errors.New("failed to encode message")
+	}
+	return h.Broker.Publish(topic, envelope)
+}
+
+// subscribeTopic starts receiving a broker topic; the caller must hold the lock
+func (h *Hub) subscribeTopic(topic string) {
+	if _, ok := h.topics[topic]; ok {
+		return
+	}
+
+	unsubscribe, err := h.Broker.Subscribe(topic, func(envelope []byte) {
+		var msg hubMessage
+		if err := json.Unmarshal(envelope, &msg); err != nil {
+			h.logger.Errorf("Failed to decode message from %s: %v", topic, err)
+			return
+		}
+		h.broadcast <- msg
+	})
+	if err != nil {
+		h.logger.Errorf("Failed to subscribe to %s: %v", topic, err)
+		return
+	}
+	h.topics[topic] = unsubscribe
+}
+
+// unsubscribeTopic stops receiving a broker topic; the caller must hold the lock
+func (h *Hub) unsubscribeTopic(topic string) {
+	if unsubscribe, ok := h.topics[topic]; ok {
+		unsubscribe()
+		delete(h.topics, topic)
+	}
+}
+
+// checkMembership verifies that a user belongs to a chatroom and returns the chatroom's member IDs
+func (h *Hub) checkMembership(userID uint, chatroomID string) (map[uint]bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(chatroomID)
+	if err != nil {
+		return nil, errors.New("invalid chatroom ID")
+	}
+
+	chatroom, err := h.ChatSvc.GetChatroomByID(objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !h.ChatSvc.IsMember(chatroom, userID) {
+		return nil, errors.New("user is not a member of this chatroom")
+	}
+
+	members := make(map[uint]bool, len(chatroom.Members))
+	for _, member := range chatroom.Members {
+		members[member.UserID] = true
+	}
+
+	return members, nil
+}
+
+// removeFromRoom removes a client from a room; the caller must hold the lock
+func (h *Hub) removeFromRoom(chatroomID string, client *Client) {
+	delete(h.rooms[chatroomID], client)
+	if len(h.rooms[chatroomID]) == 0 {
+		delete(h.rooms, chatroomID)
+		h.unsubscribeTopic(services.RoomTopic(chatroomID))
+	}
+}
+
+// handleBroadcasts delivers messages received from the broker to local clients
+func (h *Hub) handleBroadcasts() {
+	for {
+		msg := <-h.broadcast
+
+		// Queue the message for its recipients.
+		// Send never blocks; clients that cannot keep up are evicted.
+		h.mux.Lock()
+		if msg.ChatroomID != "" {
+			for client := range h.rooms[msg.ChatroomID] {
+				if !msg.Members[client.UserID] || client.UserID == msg.ExcludeID {
+					continue
+				}
+
+				// Hold the message back while the client replays what it missed
+				if sub := h.subscribed[client][msg.ChatroomID]; sub != nil && sub.resuming {
+					if len(sub.pending) >= client.config.SendBufferSize {
+						h.logger.Warnf("Resume buffer overflow for user %d, disconnecting", client.UserID)
+						client.Close(websocket.CloseTryAgainLater, "resume buffer overflow")
+						continue
+					}
+					sub.pending = append(sub.pending, msg)
+					continue
+				}
+
+				client.Send(msg.Payload)
+			}
+		} else if len(msg.CloseTokenIDs) > 0 {
+			revoked := make(map[string]bool, len(msg.CloseTokenIDs))
+			for _, tokenID := range msg.CloseTokenIDs {
+				revoked[tokenID] = true
+			}
+			for _, userID := range msg.UserIDs {
+				for client := range h.clients[userID] {
+					if revoked[client.TokenID] {
+						client.Close(websocket.ClosePolicyViolation, "token revoked")
+					}
+				}
+			}
+		} else {
+			for _, userID := range msg.UserIDs {
+				for client := range h.clients[userID] {
+					client.Send(msg.Payload)
+				}
+			}
+		}
+		h.mux.Unlock()
+	}
+}
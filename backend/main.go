@@ -10,6 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/ginchat/models"
 	"github.com/ginchat/routes"
+	"github.com/ginchat/services"
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 	swaggerFiles "github.com/swaggo/files"
@@ -125,7 +126,7 @@ func setupRouter() *gin.Engine {
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Last-Event-ID")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
@@ -145,11 +146,33 @@ func setupRouter() *gin.Engine {
 func initDatabase() {
 	// Auto migrate MySQL models
 	if mysqlDB != nil {
-		err := mysqlDB.AutoMigrate(&models.User{})
+		// Accounts created before email verification existed never got a link, so they are trusted as verified
+		backfillVerified := mysqlDB.Migrator().HasTable(&models.User{}) && !mysqlDB.Migrator().HasColumn(&models.User{}, "email_verified")
+
+		err := mysqlDB.AutoMigrate(&models.User{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.Session{}, &models.AuditLog{}, &models.UserToken{}, &models.RecoveryCode{}, &models.Identity{}, &models.OIDCLoginState{}, &models.PresenceConnection{})
 		if err != nil {
 			logger.Fatalf("Failed to migrate MySQL models: %v", err)
 		}
 		logger.Info("MySQL models migrated successfully")
+
+		if backfillVerified {
+			result := mysqlDB.Model(&models.User{}).Where("email_verified = ?", false).Updates(map[string]interface{}{
+				"email_verified":    true,
+				"email_verified_at": gorm.Expr("created_at"),
+			})
+			if result.Error != nil {
+				logger.Fatalf("Failed to mark existing users as verified: %v", result.Error)
+			}
+			logger.Infof("Marked %d existing users as verified", result.RowsAffected)
+		}
+	}
+
+	// Create MongoDB indexes
+	if mongoDB != nil {
+		if err := services.EnsureIndexes(mongoDB); err != nil {
+			logger.Fatalf("Failed to create MongoDB indexes: %v", err)
+		}
+		logger.Info("MongoDB indexes created successfully")
 	}
 }
 
@@ -3,13 +3,16 @@ package middleware
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ginchat/services"
 	"github.com/ginchat/utils"
 )
 
-// AuthMiddleware is a middleware for authenticating users using JWT
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware is a middleware for authenticating users using JWT.
+// Tokens revoked before their expiry (e.g. on logout) and disabled accounts are rejected.
+func AuthMiddleware(revocations *services.RevocationService, authz *services.AuthorizationService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get Authorization header
 		authHeader := c.GetHeader("Authorization")
@@ -38,11 +41,80 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Reject revoked tokens
+		revoked, err := revocations.IsRevoked(claims.Id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify token"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
+			return
+		}
+
+		// Reject disabled accounts
+		disabled, err := authz.IsDisabled(claims.UserID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			c.Abort()
+			return
+		}
+		if disabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+			c.Abort()
+			return
+		}
+
 		// Set user information in context
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("email", claims.Email)
 		c.Set("role", claims.Role)
+		c.Set("token_id", claims.Id)
+		c.Set("token_expires_at", time.Unix(claims.ExpiresAt, 0))
+
+		c.Next()
+	}
+}
+
+// StreamAuthMiddleware authenticates event streams with a single-use ticket
+// in the ticket query parameter, since the browser EventSource API cannot
+// send an Authorization header. Requests without a ticket go through AuthMiddleware.
+func StreamAuthMiddleware(tickets *services.StreamTicketService, revocations *services.RevocationService, authz *services.AuthorizationService) gin.HandlerFunc {
+	authenticate := AuthMiddleware(revocations, authz)
+
+	return func(c *gin.Context) {
+		ticket := c.Query("ticket")
+		if ticket == "" {
+			authenticate(c)
+			return
+		}
+
+		userID, tokenID, err := tickets.Redeem(ticket)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired ticket"})
+			c.Abort()
+			return
+		}
+
+		// Reject disabled accounts
+		disabled, err := authz.IsDisabled(userID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			c.Abort()
+			return
+		}
+		if disabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+			c.Abort()
+			return
+		}
+
+		// The role is resolved by RequirePermission
+		c.Set("user_id", userID)
+		c.Set("token_id", tokenID)
 
 		c.Next()
 	}
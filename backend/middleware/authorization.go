@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/services"
+)
+
+// RequireRole only lets through users whose current role is one of the given roles.
+// It must run after AuthMiddleware.
+func RequireRole(authz *services.AuthorizationService, roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, ok := currentRole(c, authz)
+		if !ok {
+			return
+		}
+
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		c.Abort()
+	}
+}
+
+// RequirePermission only lets through users whose current role grants the permission.
+// It must run after AuthMiddleware.
+func RequirePermission(authz *services.AuthorizationService, permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, ok := currentRole(c, authz)
+		if !ok {
+			return
+		}
+
+		if !services.HasPermission(role, permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireVerifiedEmail blocks users who have not verified their email address
+// when the verification policy requires it for sending messages.
+// It must run after AuthMiddleware.
+func RequireVerifiedEmail(verification *services.EmailVerificationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			c.Abort()
+			return
+		}
+
+		allowed, err := verification.CanSendMessages(userID.(uint))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			c.Abort()
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email not verified"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// currentRole replaces the role from the token with the user's current role,
+// aborting the request if it cannot be resolved
+func currentRole(c *gin.Context, authz *services.AuthorizationService) (string, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		c.Abort()
+		return "", false
+	}
+
+	role, err := authz.Role(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		c.Abort()
+		return "", false
+	}
+
+	c.Set("role", role)
+	return role, true
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// AuditLog records a security-relevant action and who performed it
+type AuditLog struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	ActorID   *uint     `gorm:"index" json:"actor_id"`  // User who performed the action, nil if anonymous
+	TargetID  *uint     `gorm:"index" json:"target_id"` // User the action applied to
+	Action    string    `gorm:"size:50;not null;index" json:"action"`
+	Details   string    `gorm:"type:text" json:"details"`
+	IPAddress string    `gorm:"size:45" json:"ip_address"`
+	UserAgent string    `gorm:"size:255" json:"user_agent"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// TableName specifies the table name for the AuditLog model
+func (AuditLog) TableName() string {
+	return "audit_logs"
+}
@@ -17,11 +17,12 @@ type Chatroom struct {
 
 // ChatroomResponse is a struct for returning chatroom data
 type ChatroomResponse struct {
-	ID        string           `json:"id"`
-	Name      string           `json:"name"`
-	CreatedBy uint             `json:"created_by"`
-	CreatedAt time.Time        `json:"created_at"`
-	Members   []ChatroomMember `json:"members"`
+	ID          string           `json:"id"`
+	Name        string           `json:"name"`
+	CreatedBy   uint             `json:"created_by"`
+	CreatedAt   time.Time        `json:"created_at"`
+	Members     []ChatroomMember `json:"members"`
+	UnreadCount int64            `json:"unread_count"` // Unread messages for the requesting user
 }
 
 // ToResponse converts a Chatroom to a ChatroomResponse
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+// Identity links a user to an account at an external OpenID Connect provider
+type Identity struct {
+	ID          uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	Provider    string     `gorm:"size:50;not null;uniqueIndex:idx_identities_provider_subject" json:"provider"`
+	Subject     string     `gorm:"size:255;not null;uniqueIndex:idx_identities_provider_subject" json:"subject"` // The provider's stable user ID (sub claim)
+	Email       string     `gorm:"size:100" json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// TableName specifies the table name for the Identity model
+func (Identity) TableName() string {
+	return "identities"
+}
+
+// OIDCLoginState is a login started with an OpenID Connect provider, kept
+// until the provider redirects back. Only a hash of the state is stored.
+type OIDCLoginState struct {
+	StateHash    string    `gorm:"primaryKey;size:64" json:"-"`
+	Provider     string    `gorm:"size:50;not null" json:"provider"`
+	Nonce        string    `gorm:"size:64;not null" json:"-"`
+	CodeVerifier string    `gorm:"size:128;not null" json:"-"` // PKCE code verifier
+	ExpiresAt    time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for the OIDCLoginState model
+func (OIDCLoginState) TableName() string {
+	return "oidc_login_states"
+}
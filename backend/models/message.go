@@ -8,38 +8,47 @@ import (
 
 // Message represents a message in a chatroom
 type Message struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	ChatroomID  primitive.ObjectID `bson:"chatroom_id" json:"chatroom_id"`
-	SenderID    uint               `bson:"sender_id" json:"sender_id"`
-	SenderName  string             `bson:"sender_name" json:"sender_name"`
-	MessageType string             `bson:"message_type" json:"message_type"` // text, picture, audio, video, etc.
-	TextContent string             `bson:"text_content,omitempty" json:"text_content,omitempty"`
-	MediaURL    string             `bson:"media_url,omitempty" json:"media_url,omitempty"`
-	SentAt      time.Time          `bson:"sent_at" json:"sent_at"`
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ChatroomID      primitive.ObjectID `bson:"chatroom_id" json:"chatroom_id"`
+	SenderID        uint               `bson:"sender_id" json:"sender_id"`
+	SenderName      string             `bson:"sender_name" json:"sender_name"`
+	MessageType     string             `bson:"message_type" json:"message_type"` // text, picture, audio, video, etc.
+	TextContent     string             `bson:"text_content,omitempty" json:"text_content,omitempty"`
+	MediaURL        string             `bson:"media_url,omitempty" json:"media_url,omitempty"`
+	SentAt          time.Time          `bson:"sent_at" json:"sent_at"`
+	ClientMessageID string             `bson:"client_message_id,omitempty" json:"client_message_id,omitempty"` // Sender-generated ID used to deduplicate retries
+	Edited          bool               `bson:"edited,omitempty" json:"edited,omitempty"`
+	EditedAt        *time.Time         `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
 }
 
 // MessageResponse is a struct for returning message data
 type MessageResponse struct {
-	ID          string    `json:"id"`
-	ChatroomID  string    `json:"chatroom_id"`
-	SenderID    uint      `json:"sender_id"`
-	SenderName  string    `json:"sender_name"`
-	MessageType string    `json:"message_type"`
-	TextContent string    `json:"text_content,omitempty"`
-	MediaURL    string    `json:"media_url,omitempty"`
-	SentAt      time.Time `json:"sent_at"`
+	ID              string     `json:"id"`
+	ChatroomID      string     `json:"chatroom_id"`
+	SenderID        uint       `json:"sender_id"`
+	SenderName      string     `json:"sender_name"`
+	MessageType     string     `json:"message_type"`
+	TextContent     string     `json:"text_content,omitempty"`
+	MediaURL        string     `json:"media_url,omitempty"`
+	SentAt          time.Time  `json:"sent_at"`
+	ClientMessageID string     `json:"client_message_id,omitempty"`
+	Edited          bool       `json:"edited,omitempty"`
+	EditedAt        *time.Time `json:"edited_at,omitempty"`
 }
 
 // ToResponse converts a Message to a MessageResponse
 func (m *Message) ToResponse() MessageResponse {
 	return MessageResponse{
-		ID:          m.ID.Hex(),
-		ChatroomID:  m.ChatroomID.Hex(),
-		SenderID:    m.SenderID,
-		SenderName:  m.SenderName,
-		MessageType: m.MessageType,
-		TextContent: m.TextContent,
-		MediaURL:    m.MediaURL,
-		SentAt:      m.SentAt,
+		ID:              m.ID.Hex(),
+		ChatroomID:      m.ChatroomID.Hex(),
+		SenderID:        m.SenderID,
+		SenderName:      m.SenderName,
+		MessageType:     m.MessageType,
+		TextContent:     m.TextContent,
+		MediaURL:        m.MediaURL,
+		SentAt:          m.SentAt,
+		ClientMessageID: m.ClientMessageID,
+		Edited:          m.Edited,
+		EditedAt:        m.EditedAt,
 	}
 }
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// PresenceConnection counts a user's open real-time connections on one
+// backend instance, so every instance can tell whether the user is still
+// connected somewhere. Instances refresh their rows while running; rows of
+// an instance that stopped refreshing them are discarded.
+type PresenceConnection struct {
+	InstanceID  string    `gorm:"primaryKey;size:64" json:"instance_id"`
+	UserID      uint      `gorm:"primaryKey" json:"user_id"`
+	Connections int       `gorm:"not null" json:"connections"`
+	UpdatedAt   time.Time `gorm:"not null;index" json:"updated_at"`
+}
+
+// TableName specifies the table name for the PresenceConnection model
+func (PresenceConnection) TableName() string {
+	return "presence_connections"
+}
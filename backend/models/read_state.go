@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReadState records the last message a user has read in a chatroom
+type ReadState struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ChatroomID        primitive.ObjectID `bson:"chatroom_id" json:"chatroom_id"`
+	UserID            uint               `bson:"user_id" json:"user_id"`
+	Username          string             `bson:"username" json:"username"`
+	LastReadMessageID primitive.ObjectID `bson:"last_read_message_id" json:"last_read_message_id"`
+	ReadAt            time.Time          `bson:"read_at" json:"read_at"`
+}
+
+// ReadStateResponse is a struct for returning read state data
+type ReadStateResponse struct {
+	ChatroomID        string    `json:"chatroom_id"`
+	UserID            uint      `json:"user_id"`
+	Username          string    `json:"username"`
+	LastReadMessageID string    `json:"last_read_message_id"`
+	ReadAt            time.Time `json:"read_at"`
+}
+
+// ToResponse converts a ReadState to a ReadStateResponse
+func (r *ReadState) ToResponse() ReadStateResponse {
+	return ReadStateResponse{
+		ChatroomID:        r.ChatroomID.Hex(),
+		UserID:            r.UserID,
+		Username:          r.Username,
+		LastReadMessageID: r.LastReadMessageID.Hex(),
+		ReadAt:            r.ReadAt,
+	}
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// RefreshToken is an opaque, single-use token that renews an access token.
+// Tokens rotated from the same login share a FamilyID.
+type RefreshToken struct {
+	ID            uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID        uint       `gorm:"not null;index" json:"user_id"`
+	FamilyID      string     `gorm:"size:64;not null;index" json:"family_id"`
+	TokenHash     string     `gorm:"size:64;not null;uniqueIndex" json:"-"` // SHA-256 of the token, never the token itself
+	AccessTokenID string     `gorm:"size:64;index" json:"access_token_id"`  // jti of the access token issued alongside
+	ExpiresAt     time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt        *time.Time `json:"used_at"`
+	RevokedAt     *time.Time `json:"revoked_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// TableName specifies the table name for the RefreshToken model
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// RevokedToken records an access token that was revoked before it expired.
+// Rows are kept until the token would have expired anyway.
+type RevokedToken struct {
+	TokenID   string    `gorm:"primaryKey;size:64" json:"token_id"` // jti of the access token
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	Reason    string    `gorm:"size:50" json:"reason"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for the RevokedToken model
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+// Session is a login on one device. It spans every refresh token rotated
+// from that login, so its ID is the refresh token family ID.
+type Session struct {
+	ID         string     `gorm:"primaryKey;size:64" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	Device     string     `gorm:"size:100" json:"device"`
+	UserAgent  string     `gorm:"size:255" json:"user_agent"`
+	IPAddress  string     `gorm:"size:45" json:"ip_address"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// SessionResponse is a struct for returning session data
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	Current    bool      `json:"current"`
+}
+
+// TableName specifies the table name for the Session model
+func (Session) TableName() string {
+	return "sessions"
+}
+
+// ToResponse converts a Session to a SessionResponse
+func (s *Session) ToResponse(current bool) SessionResponse {
+	return SessionResponse{
+		ID:         s.ID,
+		Device:     s.Device,
+		UserAgent:  s.UserAgent,
+		IPAddress:  s.IPAddress,
+		LastSeenAt: s.LastSeenAt,
+		CreatedAt:  s.CreatedAt,
+		Current:    current,
+	}
+}
@@ -8,18 +8,29 @@ import (
 
 // User represents a user in the system
 type User struct {
-	UserID      uint       `gorm:"primaryKey;autoIncrement" json:"user_id"`
-	Username    string     `gorm:"size:50;not null;unique" json:"username"`
-	Email       string     `gorm:"size:100;not null;unique" json:"email"`
-	Password    string     `gorm:"size:255;not null" json:"-"` // Password is not exposed in JSON
-	Role        string     `gorm:"size:50;default:member" json:"role"`
-	IsLogin     bool       `gorm:"default:false" json:"is_login"`
-	LastLoginAt *time.Time `json:"last_login_at"`
-	Heartbeat   *time.Time `json:"heartbeat"`
-	Status      string     `gorm:"type:enum('online','offline','away');default:'offline'" json:"status"`
-	AvatarURL   string     `gorm:"size:255" json:"avatar_url"`
-	CreatedAt   time.Time  `json:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at"`
+	UserID                uint       `gorm:"primaryKey;autoIncrement" json:"user_id"`
+	Username              string     `gorm:"size:50;not null;unique" json:"username"`
+	Email                 string     `gorm:"size:100;not null;unique" json:"email"`
+	Password              string     `gorm:"size:255;not null" json:"-"` // Password is not exposed in JSON
+	Role                  string     `gorm:"size:50;default:member" json:"role"`
+	IsLogin               bool       `gorm:"default:false" json:"is_login"`
+	LastLoginAt           *time.Time `json:"last_login_at"`
+	Heartbeat             *time.Time `json:"heartbeat"`
+	Status                string     `gorm:"type:enum('online','offline','away');default:'offline'" json:"status"`
+	AvatarURL             string     `gorm:"size:255" json:"avatar_url"`
+	Disabled              bool       `gorm:"default:false" json:"disabled"`
+	DisabledAt            *time.Time `json:"disabled_at"`
+	PasswordResetRequired bool       `gorm:"default:false" json:"password_reset_required"` // Login is refused until the password is reset
+	EmailVerified         bool       `gorm:"default:false" json:"email_verified"`
+	EmailVerifiedAt       *time.Time `json:"email_verified_at"`
+	TwoFactorEnabled      bool       `gorm:"default:false" json:"two_factor_enabled"`
+	TOTPSecret            string     `gorm:"size:64" json:"-"`   // Set on enrolment, active once two-factor is enabled
+	TOTPLastStep          int64      `gorm:"default:0" json:"-"` // Time step of the last accepted code, to refuse replays
+	FailedLoginAttempts   int        `gorm:"default:0" json:"-"`
+	LastFailedLoginAt     *time.Time `json:"-"`
+	LockedUntil           *time.Time `json:"locked_until"` // Logins are refused until then
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
 }
 
 // BeforeCreate is a GORM hook that sets the timestamps before creating a record
@@ -47,11 +58,26 @@ func (User) TableName() string {
 
 // UserResponse is a struct for returning user data without sensitive information
 type UserResponse struct {
-	UserID    uint      `json:"user_id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	Role      string    `json:"role"`
-	Status    string    `json:"status"`
-	AvatarURL string    `json:"avatar_url"`
-	CreatedAt time.Time `json:"created_at"`
+	UserID           uint      `json:"user_id"`
+	Username         string    `json:"username"`
+	Email            string    `json:"email"`
+	Role             string    `json:"role"`
+	Status           string    `json:"status"`
+	AvatarURL        string    `json:"avatar_url"`
+	EmailVerified    bool      `json:"email_verified"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// AdminUserResponse is a struct for returning user data, including account state, to admins
+type AdminUserResponse struct {
+	UserResponse
+	IsLogin               bool       `json:"is_login"`
+	LastLoginAt           *time.Time `json:"last_login_at"`
+	Disabled              bool       `json:"disabled"`
+	DisabledAt            *time.Time `json:"disabled_at"`
+	PasswordResetRequired bool       `json:"password_reset_required"`
+	FailedLoginAttempts   int        `json:"failed_login_attempts"`
+	LockedUntil           *time.Time `json:"locked_until"`
+	UpdatedAt             time.Time  `json:"updated_at"`
 }
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// UserToken is a single-use token emailed to a user, e.g. to reset their
+// password. Only a hash of the token is stored.
+type UserToken struct {
+	ID        uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	Purpose   string     `gorm:"size:30;not null;index" json:"purpose"`
+	TokenHash string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Data      string     `gorm:"size:255" json:"-"`  // Purpose-specific payload
+	Attempts  int        `gorm:"default:0" json:"-"` // Failed attempts to use the token
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TableName specifies the table name for the UserToken model
+func (UserToken) TableName() string {
+	return "user_tokens"
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/ginchat/controllers"
 	"github.com/ginchat/middleware"
 	"github.com/ginchat/services"
+	"github.com/ginchat/utils"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/mongo"
 	"gorm.io/gorm"
@@ -13,18 +14,73 @@ import (
 // SetupRoutes configures all the routes for the application
 func SetupRoutes(r *gin.Engine, db *gorm.DB, mongodb *mongo.Database, logger *logrus.Logger) {
 	// Create services
-	userService := services.NewUserService(db)
-	// Create chatroom and message services but comment them out until they're used
-	// chatroomService := services.NewChatroomService(mongodb)
-	// messageService := services.NewMessageService(mongodb, chatroomService)
+	passwordHashers, err := services.NewPasswordHashers()
+	if err != nil {
+		logger.Fatalf("Failed to configure password hashing: %v", err)
+	}
+	userService := services.NewUserService(db, passwordHashers)
+	revocationService := services.NewRevocationService(db)
+	tokenService := services.NewTokenService(db, revocationService)
+	authorizationService := services.NewAuthorizationService(db)
+	auditService := services.NewAuditService(db)
+	userTokenService := services.NewUserTokenService(db)
+	mailer, err := services.NewMailer(logger)
+	if err != nil {
+		logger.Fatalf("Failed to create mailer: %v", err)
+	}
+	passwordResetService := services.NewPasswordResetService(db, userService, tokenService, userTokenService, mailer)
+	emailVerificationService := services.NewEmailVerificationService(db, authorizationService, userTokenService, mailer)
+	emailChangeService := services.NewEmailChangeService(db, authorizationService, userTokenService, mailer)
+	twoFactorService := services.NewTwoFactorService(db, userTokenService)
+	loginProtectionService := services.NewLoginProtectionService(db)
+	mailThrottle := services.NewMailThrottle()
+	passwordPolicy := services.LoadPasswordPolicy(logger)
+	oidcService := services.NewOIDCService(db, userService, userTokenService)
+	streamTicketService := services.NewStreamTicketService(userTokenService, revocationService)
+	chatroomService := services.NewChatroomService(mongodb)
+	messageService := services.NewMessageService(mongodb, chatroomService)
+	presenceService := services.NewPresenceService(db, chatroomService)
+	typingService := services.NewTypingService()
+
+	// Load the signing keys and rotate them on schedule when tokens are signed with key pairs
+	if utils.JWTAlgorithm() != utils.AlgorithmHS256 {
+		keys, err := utils.Keys()
+		if err != nil {
+			logger.Fatalf("Failed to load JWT signing keys: %v", err)
+		}
+		keys.Start(func(err error) {
+			logger.Errorf("JWT signing keys: %v", err)
+		})
+	}
+
+	// Create the broker and the WebSocket hub shared by real-time controllers
+	broker, err := services.NewBroker(mongodb, logger)
+	if err != nil {
+		logger.Fatalf("Failed to create broker: %v", err)
+	}
+	hub := controllers.NewHub(chatroomService, broker, logger)
+	presenceService.OnChange(hub.PublishPresence)
+	presenceService.Start()
+	typingService.OnChange(hub.PublishTyping)
+	revocationService.OnRevoke(hub.CloseRevoked)
+	revocationService.Start()
+	loginProtectionService.Start()
+	mailThrottle.Start()
 
 	// Create controllers
-	userController := controllers.NewUserController(db, userService)
-	chatroomController := controllers.NewChatroomController(db, mongodb)
-	messageController := controllers.NewMessageController(db, mongodb)
-	// Use the messageService when the MessageController is updated to accept it
-	// messageController := controllers.NewMessageController(db, messageService)
-	websocketController := controllers.NewWebSocketController(logger)
+	userController := controllers.NewUserController(db, userService, tokenService, emailVerificationService, twoFactorService, loginProtectionService, auditService, passwordPolicy)
+	sessionController := controllers.NewSessionController(db, tokenService)
+	keyController := controllers.NewKeyController()
+	adminController := controllers.NewAdminController(db, userService, tokenService, authorizationService, auditService, passwordResetService, loginProtectionService)
+	passwordController := controllers.NewPasswordController(db, passwordResetService, auditService, passwordPolicy, mailThrottle)
+	verificationController := controllers.NewVerificationController(db, emailVerificationService, auditService, mailThrottle)
+	accountController := controllers.NewAccountController(db, userService, tokenService, emailChangeService, auditService, passwordPolicy, loginProtectionService, mailThrottle)
+	twoFactorController := controllers.NewTwoFactorController(db, userService, tokenService, twoFactorService, auditService, loginProtectionService)
+	oidcController := controllers.NewOIDCController(db, userService, tokenService, oidcService, emailVerificationService, twoFactorService, auditService, loginProtectionService)
+	chatroomController := controllers.NewChatroomController(db, chatroomService, messageService)
+	messageController := controllers.NewMessageController(db, messageService, hub)
+	websocketController := controllers.NewWebSocketController(hub, messageService, presenceService, typingService, emailVerificationService, logger)
+	eventStreamController := controllers.NewEventStreamController(hub, messageService, chatroomService, presenceService, streamTicketService, logger)
 
 	// Health check endpoint
 	r.GET("/health", func(c *gin.Context) {
@@ -33,6 +89,9 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, mongodb *mongo.Database, logger *lo
 		})
 	})
 
+	// Public keys for verifying tokens
+	r.GET("/.well-known/jwks.json", keyController.JWKS)
+
 	// Swagger documentation is set up in main.go
 
 	// API routes
@@ -43,26 +102,89 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, mongodb *mongo.Database, logger *lo
 		{
 			auth.POST("/register", userController.Register)
 			auth.POST("/login", userController.Login)
+			auth.POST("/login/2fa", twoFactorController.VerifyLogin)
+			auth.POST("/refresh", userController.Refresh)
+			auth.POST("/password/forgot", passwordController.ForgotPassword)
+			auth.POST("/password/reset", passwordController.ResetPassword)
+			auth.GET("/verify", verificationController.VerifyEmail)
+			auth.POST("/verify/resend", verificationController.ResendVerification)
+			auth.GET("/email/confirm", accountController.ConfirmEmailChange)
+			auth.GET("/oidc/providers", oidcController.ListProviders)
+			auth.GET("/oidc/:provider/login", oidcController.Login)
+			auth.GET("/oidc/:provider/callback", oidcController.Callback)
+			auth.POST("/oidc/exchange", oidcController.Exchange)
 		}
 
 		// Protected routes (auth required)
 		protected := api.Group("/")
-		protected.Use(middleware.AuthMiddleware())
+		protected.Use(middleware.AuthMiddleware(revocationService, authorizationService))
 		{
 			// User routes
 			protected.POST("/auth/logout", userController.Logout)
+			protected.GET("/auth/sessions", sessionController.GetSessions)
+			protected.DELETE("/auth/sessions/:id", sessionController.RevokeSession)
+			protected.GET("/auth/2fa", twoFactorController.GetStatus)
+			protected.POST("/auth/2fa/setup", twoFactorController.Setup)
+			protected.POST("/auth/2fa/confirm", twoFactorController.Confirm)
+			protected.POST("/auth/2fa/disable", twoFactorController.Disable)
+			protected.POST("/auth/2fa/recovery-codes", twoFactorController.RegenerateRecoveryCodes)
+			protected.PUT("/users/me/password", accountController.ChangePassword)
+			protected.PUT("/users/me/email", accountController.RequestEmailChange)
+
+		}
 
+		// Chat routes readable by every role in the permission matrix
+		chatRead := protected.Group("/")
+		chatRead.Use(middleware.RequirePermission(authorizationService, services.PermissionChatRead))
+		{
+			// Chatroom and message history routes
+			chatRead.GET("/chatrooms", chatroomController.GetChatrooms)
+			chatRead.GET("/chatrooms/:id/messages", messageController.GetMessages)
+
+			// Tickets authenticating the event stream for browsers
+			chatRead.POST("/events/ticket", eventStreamController.IssueTicket)
+		}
+
+		// Server-Sent Events fallback for clients that cannot use WebSocket,
+		// authenticated by a ticket or the Authorization header
+		events := api.Group("/events")
+		events.Use(middleware.StreamAuthMiddleware(streamTicketService, revocationService, authorizationService))
+		events.Use(middleware.RequirePermission(authorizationService, services.PermissionChatRead))
+		{
+			events.GET("", eventStreamController.Stream)
+		}
+
+		// Chat routes that change state
+		chatWrite := protected.Group("/")
+		chatWrite.Use(middleware.RequirePermission(authorizationService, services.PermissionChatWrite))
+		{
 			// Chatroom routes
-			protected.GET("/chatrooms", chatroomController.GetChatrooms)
-			protected.POST("/chatrooms", chatroomController.CreateChatroom)
-			protected.POST("/chatrooms/:id/join", chatroomController.JoinChatroom)
+			chatWrite.POST("/chatrooms", middleware.RequirePermission(authorizationService, services.PermissionChatroomCreate), chatroomController.CreateChatroom)
+			chatWrite.POST("/chatrooms/:id/join", chatroomController.JoinChatroom)
 
 			// Message routes
-			protected.GET("/chatrooms/:id/messages", messageController.GetMessages)
-			protected.POST("/chatrooms/:id/messages", messageController.SendMessage)
+			chatWrite.POST("/chatrooms/:id/messages", middleware.RequireVerifiedEmail(emailVerificationService), messageController.SendMessage)
+			chatWrite.POST("/chatrooms/:id/read", messageController.MarkRead)
+			chatWrite.PUT("/chatrooms/:id/messages/:message_id", messageController.EditMessage)
+			chatWrite.DELETE("/chatrooms/:id/messages/:message_id", messageController.DeleteMessage)
 
 			// WebSocket route
-			protected.GET("/ws", websocketController.HandleConnection)
+			chatWrite.GET("/ws", websocketController.HandleConnection)
+		}
+
+		// Admin routes
+		admin := protected.Group("/admin")
+		admin.Use(middleware.RequirePermission(authorizationService, services.PermissionUserManage))
+		{
+			admin.GET("/users", adminController.ListUsers)
+			admin.GET("/users/:id", adminController.GetUser)
+			admin.PUT("/users/:id/role", adminController.UpdateRole)
+			admin.POST("/users/:id/disable", adminController.DisableUser)
+			admin.POST("/users/:id/enable", adminController.EnableUser)
+			admin.POST("/users/:id/unlock", adminController.UnlockUser)
+			admin.POST("/users/:id/force-password-reset", adminController.ForcePasswordReset)
+			admin.POST("/users/:id/force-logout", adminController.ForceLogout)
+			admin.GET("/audit-logs", adminController.GetAuditLogs)
 		}
 	}
 }
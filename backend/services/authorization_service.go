@@ -0,0 +1,157 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+	"gorm.io/gorm"
+)
+
+// User roles stored in models.User.Role
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+// Permissions checked by the authorization middleware
+const (
+	PermissionChatRead       = "chat:read"       // List chatrooms, read messages, receive live events
+	PermissionChatWrite      = "chat:write"      // Join chatrooms, send messages, typing and read receipts
+	PermissionChatroomCreate = "chatroom:create" // Create chatrooms
+	PermissionChatModerate   = "chat:moderate"   // Moderate other users' chat activity
+	PermissionUserManage     = "user:manage"     // Manage user accounts
+)
+
+// rolePermissions is the permission matrix: the permissions granted to each role
+var rolePermissions = map[string][]string{
+	RoleMember: {
+		PermissionChatRead,
+		PermissionChatWrite,
+		PermissionChatroomCreate,
+	},
+	RoleModerator: {
+		PermissionChatRead,
+		PermissionChatWrite,
+		PermissionChatroomCreate,
+		PermissionChatModerate,
+	},
+	RoleAdmin: {
+		PermissionChatRead,
+		PermissionChatWrite,
+		PermissionChatroomCreate,
+		PermissionChatModerate,
+		PermissionUserManage,
+	},
+}
+
+// IsValidRole reports whether a role is part of the permission matrix
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether a role grants a permission
+func HasPermission(role, permission string) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// cachedUser is a user's role and account state as last read from the database
+type cachedUser struct {
+	role          string
+	disabled      bool
+	emailVerified bool
+	expires       time.Time
+}
+
+// AuthorizationService resolves users' current roles and account state.
+// The role in a token can be stale, so roles are read from the database and
+// cached briefly; a role change takes effect within CacheTTL on every instance.
+type AuthorizationService struct {
+	DB *gorm.DB
+
+	// CacheTTL is how long a role read from the database is reused
+	CacheTTL time.Duration
+
+	users map[uint]cachedUser
+	mux   sync.Mutex
+}
+
+// NewAuthorizationService creates a new AuthorizationService
+func NewAuthorizationService(db *gorm.DB) *AuthorizationService {
+	return &AuthorizationService{
+		DB:       db,
+		CacheTTL: utils.GetEnvDuration("ROLE_CACHE_TTL", 30*time.Second),
+		users:    make(map[uint]cachedUser),
+	}
+}
+
+// Role returns a user's current role
+func (s *AuthorizationService) Role(userID uint) (string, error) {
+	user, err := s.lookup(userID)
+	if err != nil {
+		return "", err
+	}
+	return user.role, nil
+}
+
+// IsDisabled reports whether a user's account is disabled
+func (s *AuthorizationService) IsDisabled(userID uint) (bool, error) {
+	user, err := s.lookup(userID)
+	if err != nil {
+		return false, err
+	}
+	return user.disabled, nil
+}
+
+// IsEmailVerified reports whether a user has verified their email address
+func (s *AuthorizationService) IsEmailVerified(userID uint) (bool, error) {
+	user, err := s.lookup(userID)
+	if err != nil {
+		return false, err
+	}
+	return user.emailVerified, nil
+}
+
+// Invalidate drops a user's cached role and account state, e.g. after they were changed
+func (s *AuthorizationService) Invalidate(userID uint) {
+	s.mux.Lock()
+	delete(s.users, userID)
+	s.mux.Unlock()
+}
+
+// lookup returns a user's cached role and account state, reading them from the database when stale
+func (s *AuthorizationService) lookup(userID uint) (cachedUser, error) {
+	now := time.Now()
+
+	s.mux.Lock()
+	cached, ok := s.users[userID]
+	s.mux.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached, nil
+	}
+
+	var user models.User
+	if result := s.DB.Select("user_id", "role", "disabled", "email_verified").First(&user, userID); result.Error != nil {
+		return cachedUser{}, errors.New("user not found")
+	}
+
+	cached = cachedUser{
+		role:          user.Role,
+		disabled:      user.Disabled,
+		emailVerified: user.EmailVerified,
+		expires:       now.Add(s.CacheTTL),
+	}
+	s.mux.Lock()
+	s.users[userID] = cached
+	s.mux.Unlock()
+	return cached, nil
+}
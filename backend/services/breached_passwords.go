@@ -0,0 +1,79 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachedPrefixLength is the number of SHA-1 hex characters that name a corpus file
+const BreachedPrefixLength = 5
+
+// BreachedPasswordList checks passwords against an on-disk corpus of breached
+// password hashes, laid out like the k-anonymity range files of Have I Been
+// Pwned: one <PREFIX>.txt file per 5-character SHA-1 prefix, each line holding
+// the remaining 35 characters of a hash and how often it was seen, as
+// SUFFIX:COUNT. Only the file for the password's prefix is read.
+type BreachedPasswordList struct {
+	Dir string
+	// MinCount is how often a password must have been seen to be refused
+	MinCount int
+}
+
+// NewBreachedPasswordList creates a BreachedPasswordList reading the corpus in dir
+func NewBreachedPasswordList(dir string, minCount int) *BreachedPasswordList {
+	return &BreachedPasswordList{
+		Dir:      dir,
+		MinCount: minCount,
+	}
+}
+
+// Available reports whether the corpus directory exists
+func (l *BreachedPasswordList) Available() bool {
+	info, err := os.Stat(l.Dir)
+	return err == nil && info.IsDir()
+}
+
+// Contains reports whether a password is in the corpus
+func (l *BreachedPasswordList) Contains(password string) (bool, error) {
+	prefix, suffix := BreachedPasswordHash(password)
+
+	file, err := os.Open(filepath.Join(l.Dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		hash, count, _ := strings.Cut(line, ":")
+		if !strings.EqualFold(hash, suffix) {
+			continue
+		}
+
+		// Lines without a count are treated as seen once
+		seen := 1
+		if n, err := strconv.Atoi(count); err == nil {
+			seen = n
+		}
+		return seen >= l.MinCount, nil
+	}
+	return false, scanner.Err()
+}
+
+// BreachedPasswordHash splits the uppercase SHA-1 hex digest of a password
+// into the prefix naming its corpus file and the suffix stored in it
+func BreachedPasswordHash(password string) (string, string) {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return digest[:BreachedPrefixLength], digest[BreachedPrefixLength:]
+}
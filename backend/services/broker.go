@@ -0,0 +1,105 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Broker delivers payloads published on a topic to every subscriber of that
+// topic, on this instance and, depending on the implementation, on others
+type Broker interface {
+	// Publish sends a payload to the subscribers of a topic
+	Publish(topic string, payload []byte) error
+	// Subscribe registers a handler for a topic and returns a function that removes it
+	Subscribe(topic string, handler func(payload []byte)) (func(), error)
+	// Close releases the broker's resources
+	Close() error
+}
+
+// RoomTopic returns the broker topic for a chatroom
+func RoomTopic(chatroomID string) string {
+	return "room:" + chatroomID
+}
+
+// UserTopic returns the broker topic for a user
+func UserTopic(userID uint) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// NewBroker creates the broker selected by the BROKER environment variable:
+// "memory" (default) for a single instance, or "mongo" to fan out across
+// instances through a MongoDB change stream
+func NewBroker(mongodb *mongo.Database, logger *logrus.Logger) (Broker, error) {
+	switch os.Getenv("BROKER") {
+	case "", "memory":
+		return NewMemoryBroker(), nil
+	case "mongo":
+		return NewMongoBroker(mongodb, logger)
+	default:
+		return nil, errors.New("unknown broker: " + os.Getenv("BROKER"))
+	}
+}
+
+// MemoryBroker is an in-process Broker for single-instance deployments
+type MemoryBroker struct {
+	handlers map[string]map[int]func(payload []byte)
+	nextID   int
+	mux      sync.RWMutex
+}
+
+// NewMemoryBroker creates a new MemoryBroker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		handlers: make(map[string]map[int]func(payload []byte)),
+	}
+}
+
+// Publish calls the handlers of a topic synchronously
+func (b *MemoryBroker) Publish(topic string, payload []byte) error {
+	b.mux.RLock()
+	handlers := make([]func(payload []byte), 0, len(b.handlers[topic]))
+	for _, handler := range b.handlers[topic] {
+		handlers = append(handlers, handler)
+	}
+	b.mux.RUnlock()
+
+	for _, handler := range handlers {
+		handler(payload)
+	}
+	return nil
+}
+
+// Subscribe registers a handler for a topic
+func (b *MemoryBroker) Subscribe(topic string, handler func(payload []byte)) (func(), error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	if _, ok := b.handlers[topic]; !ok {
+		b.handlers[topic] = make(map[int]func(payload []byte))
+	}
+	b.handlers[topic][id] = handler
+
+	return func() {
+		b.mux.Lock()
+		defer b.mux.Unlock()
+		delete(b.handlers[topic], id)
+		if len(b.handlers[topic]) == 0 {
+			delete(b.handlers, topic)
+		}
+	}, nil
+}
+
+// Close removes all handlers
+func (b *MemoryBroker) Close() error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.handlers = make(map[string]map[int]func(payload []byte))
+	return nil
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ginchat/utils"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// brokerEvent is a published payload stored in the broker_events collection
+type brokerEvent struct {
+	Topic     string    `bson:"topic"`
+	Payload   []byte    `bson:"payload"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// MongoBroker is a Broker for multi-instance deployments. Published payloads
+// are inserted into the broker_events collection and every instance receives
+// them through a change stream, so MongoDB must run as a replica set.
+type MongoBroker struct {
+	coll   *mongo.Collection
+	local  *MemoryBroker
+	cancel context.CancelFunc
+	logger *logrus.Logger
+}
+
+// NewMongoBroker creates a new MongoBroker and starts watching for events
+func NewMongoBroker(mongodb *mongo.Database, logger *logrus.Logger) (*MongoBroker, error) {
+	if mongodb == nil {
+		return nil, errors.New("mongo broker requires a MongoDB connection")
+	}
+
+	coll := mongodb.Collection("broker_events")
+
+	// Events are only needed while they are being delivered
+	retention := utils.GetEnvDuration("BROKER_MONGO_RETENTION", time.Minute)
+	_, err := coll.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(retention.Seconds())),
+	})
+	if err != nil {
+		return nil, errors.New("failed to create broker event index")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	broker := &MongoBroker{
+		coll:   coll,
+		local:  NewMemoryBroker(),
+		cancel: cancel,
+		logger: logger,
+	}
+
+	// Open the first change stream before returning so no event published afterwards is missed
+	stream, err := broker.watch(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go broker.run(ctx, stream)
+
+	return broker, nil
+}
+
+// Publish inserts an event that every instance's change stream delivers
+func (b *MongoBroker) Publish(topic string, payload []byte) error {
+	_, err := b.coll.InsertOne(context.Background(), brokerEvent{
+		Topic:     topic,
+		Payload:   payload,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return errors.New("failed to publish event")
+	}
+	return nil
+}
+
+// Subscribe registers a handler for events on a topic received by this instance
+func (b *MongoBroker) Subscribe(topic string, handler func(payload []byte)) (func(), error) {
+	return b.local.Subscribe(topic, handler)
+}
+
+// Close stops watching for events
+func (b *MongoBroker) Close() error {
+	b.cancel()
+	return b.local.Close()
+}
+
+// watch opens a change stream on inserted events, resuming after a token if one is given
+func (b *MongoBroker) watch(ctx context.Context, resumeToken bson.Raw) (*mongo.ChangeStream, error) {
+	opts := options.ChangeStream()
+	if resumeToken != nil {
+		opts.SetResumeAfter(resumeToken)
+	}
+
+	pipeline := mongo.Pipeline{bson.D{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+	stream, err := b.coll.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return nil, errors.New("failed to watch broker events (MongoDB must be a replica set)")
+	}
+	return stream, nil
+}
+
+// run dispatches events from the change stream to local subscribers,
+// reopening the stream after errors until the broker is closed
+func (b *MongoBroker) run(ctx context.Context, stream *mongo.ChangeStream) {
+	var resumeToken bson.Raw
+	for {
+		for stream.Next(ctx) {
+			var change struct {
+				FullDocument brokerEvent `bson:"fullDocument"`
+			}
+			if err := stream.Decode(&change); err != nil {
+				b.logger.Errorf("Failed to decode broker event: %v", err)
+				continue
+			}
+			b.local.Publish(change.FullDocument.Topic, change.FullDocument.Payload)
+			resumeToken = stream.ResumeToken()
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			b.logger.Errorf("Broker change stream failed: %v", err)
+		}
+		stream.Close(context.Background())
+
+		// Reopen the stream, resuming after the last delivered event when possible
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+			time.Sleep(time.Second)
+
+			reopened, err := b.watch(ctx, resumeToken)
+			if err == nil {
+				stream = reopened
+				break
+			}
+			b.logger.Errorf("Failed to reopen broker change stream: %v", err)
+		}
+	}
+}
@@ -79,6 +79,43 @@ func (s *ChatroomService) GetChatrooms() ([]models.Chatroom, error) {
 	return chatrooms, nil
 }
 
+// GetChatroomsByMember retrieves the chatrooms a user is a member of
+func (s *ChatroomService) GetChatroomsByMember(userID uint) ([]models.Chatroom, error) {
+	cursor, err := s.ChatColl.Find(context.Background(), bson.M{"members.user_id": userID})
+	if err != nil {
+		return nil, errors.New("failed to get chatrooms")
+	}
+	defer cursor.Close(context.Background())
+
+	var chatrooms []models.Chatroom
+	if err := cursor.All(context.Background(), &chatrooms); err != nil {
+		return nil, errors.New("failed to decode chatrooms")
+	}
+
+	return chatrooms, nil
+}
+
+// GetRoommateIDs returns the IDs of the other users who share at least one chatroom with a user
+func (s *ChatroomService) GetRoommateIDs(userID uint) ([]uint, error) {
+	chatrooms, err := s.GetChatroomsByMember(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[uint]bool)
+	var userIDs []uint
+	for _, chatroom := range chatrooms {
+		for _, member := range chatroom.Members {
+			if member.UserID != userID && !seen[member.UserID] {
+				seen[member.UserID] = true
+				userIDs = append(userIDs, member.UserID)
+			}
+		}
+	}
+
+	return userIDs, nil
+}
+
 // GetChatroomByID retrieves a chatroom by ID
 func (s *ChatroomService) GetChatroomByID(chatroomID primitive.ObjectID) (*models.Chatroom, error) {
 	var chatroom models.Chatroom
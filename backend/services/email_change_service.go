@@ -0,0 +1,142 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// EmailChangeService changes a user's email address once they prove they own
+// the new one through a single-use link sent to it
+type EmailChangeService struct {
+	DB     *gorm.DB
+	Authz  *AuthorizationService
+	Tokens *UserTokenService
+	Mailer Mailer
+
+	// TTL is how long a confirmation link stays valid
+	TTL time.Duration
+	// ResendInterval is the minimum time between two confirmation emails for a user
+	ResendInterval time.Duration
+	// ResendLimit is the maximum number of confirmation emails sent for a user per day
+	ResendLimit int
+}
+
+// NewEmailChangeService creates a new EmailChangeService
+func NewEmailChangeService(db *gorm.DB, authorizationService *AuthorizationService, userTokenService *UserTokenService, mailer Mailer) *EmailChangeService {
+	return &EmailChangeService{
+		DB:             db,
+		Authz:          authorizationService,
+		Tokens:         userTokenService,
+		Mailer:         mailer,
+		TTL:            utils.GetEnvDuration("EMAIL_CHANGE_TTL", 24*time.Hour),
+		ResendInterval: utils.GetEnvDuration("EMAIL_CHANGE_RESEND_INTERVAL", time.Minute),
+		ResendLimit:    utils.GetEnvInt("EMAIL_CHANGE_RESEND_LIMIT", 5),
+	}
+}
+
+// RequestChange emails a confirmation link for a new address to that address.
+// The user's email is only changed once the link is opened. It returns the
+// time to wait if the user asked too recently or too often.
+func (s *EmailChangeService) RequestChange(user *models.User, newEmail string) (time.Duration, error) {
+	newEmail = strings.TrimSpace(newEmail)
+	if strings.EqualFold(newEmail, user.Email) {
+		return 0, errors.New("email unchanged")
+	}
+	if s.emailTaken(newEmail, user.UserID) {
+		return 0, errors.New("user with this email already exists")
+	}
+
+	wait, err := s.Tokens.Throttle(user.UserID, TokenPurposeEmailChange, s.ResendInterval, s.ResendLimit)
+	if err != nil {
+		return 0, err
+	}
+	if wait > 0 {
+		return wait, errors.New("too many confirmation emails")
+	}
+
+	// The new address travels with the token, so a link only ever confirms the address it was sent to
+	rawToken, err := s.Tokens.Issue(user.UserID, TokenPurposeEmailChange, newEmail, s.TTL)
+	if err != nil {
+		return 0, err
+	}
+
+	body := fmt.Sprintf(`Hi %s,
+
+Someone asked to change the email address of your GinChat account to %s. To confirm the change, open this link within %s:
+
+%s/api/auth/email/confirm?token=%s
+
+If you did not ask for this, you can ignore this email.
+`, user.Username, newEmail, s.TTL, AppURL(), rawToken)
+
+	if err := s.Mailer.Send(newEmail, "Confirm your new GinChat email address", body); err != nil {
+		return 0, errors.New("failed to send confirmation email")
+	}
+	return 0, nil
+}
+
+// ConfirmChange swaps a user's email address for the one a confirmation token
+// was sent to, and returns the user and their previous address
+func (s *EmailChangeService) ConfirmChange(rawToken string) (*models.User, string, error) {
+	token, err := s.Tokens.Find(rawToken, TokenPurposeEmailChange)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var user models.User
+	if result := s.DB.First(&user, token.UserID); result.Error != nil {
+		return nil, "", errors.New("user not found")
+	}
+
+	// A conflict leaves the link unused, so it still works once the address is free
+	if s.emailTaken(token.Data, user.UserID) {
+		return nil, "", errors.New("user with this email already exists")
+	}
+	if _, err := s.Tokens.Consume(rawToken, TokenPurposeEmailChange); err != nil {
+		return nil, "", err
+	}
+
+	// Opening the link proved the user owns the new address
+	oldEmail := user.Email
+	now := time.Now()
+	result := s.DB.Model(&user).Updates(map[string]interface{}{
+		"email":             token.Data,
+		"email_verified":    true,
+		"email_verified_at": now,
+	})
+	if result.Error != nil {
+		// The unique index catches an address claimed since the check above
+		if s.emailTaken(token.Data, user.UserID) {
+			return nil, "", errors.New("user with this email already exists")
+		}
+		return nil, "", errors.New("failed to update email")
+	}
+	s.Authz.Invalidate(user.UserID)
+
+	// Let the previous address know, in case the change was not made by its owner
+	body := fmt.Sprintf(`Hi %s,
+
+The email address of your GinChat account was changed from %s to %s.
+
+If you did not make this change, reset your password and contact support.
+`, user.Username, oldEmail, user.Email)
+	if err := s.Mailer.Send(oldEmail, "Your GinChat email address was changed", body); err != nil {
+		logrus.Warnf("Failed to notify %s of email change for user %d: %v", oldEmail, user.UserID, err)
+	}
+
+	return &user, oldEmail, nil
+}
+
+// emailTaken reports whether another user has an email address
+func (s *EmailChangeService) emailTaken(email string, userID uint) bool {
+	var count int64
+	s.DB.Model(&models.User{}).Where("email = ? AND user_id <> ?", email, userID).Count(&count)
+	return count > 0
+}
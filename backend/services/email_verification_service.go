@@ -0,0 +1,144 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+	"gorm.io/gorm"
+)
+
+// Email verification policies: what an unverified user is prevented from doing
+const (
+	VerificationPolicyOff       = "off"       // Nothing is blocked
+	VerificationPolicyMessaging = "messaging" // Sending messages is blocked
+	VerificationPolicyLogin     = "login"     // Logging in is blocked
+)
+
+// EmailVerificationService confirms that users own the email address they registered with
+type EmailVerificationService struct {
+	DB     *gorm.DB
+	Authz  *AuthorizationService
+	Tokens *UserTokenService
+	Mailer Mailer
+
+	// Policy is what unverified users are prevented from doing
+	Policy string
+	// TTL is how long a verification link stays valid
+	TTL time.Duration
+	// ResendInterval is the minimum time between two verification emails to a user
+	ResendInterval time.Duration
+	// ResendLimit is the maximum number of verification emails sent to a user per day
+	ResendLimit int
+}
+
+// NewEmailVerificationService creates a new EmailVerificationService
+func NewEmailVerificationService(db *gorm.DB, authorizationService *AuthorizationService, userTokenService *UserTokenService, mailer Mailer) *EmailVerificationService {
+	policy := os.Getenv("EMAIL_VERIFICATION_POLICY")
+	if policy != VerificationPolicyMessaging && policy != VerificationPolicyLogin {
+		policy = VerificationPolicyOff
+	}
+
+	return &EmailVerificationService{
+		DB:             db,
+		Authz:          authorizationService,
+		Tokens:         userTokenService,
+		Mailer:         mailer,
+		Policy:         policy,
+		TTL:            utils.GetEnvDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+		ResendInterval: utils.GetEnvDuration("EMAIL_VERIFICATION_RESEND_INTERVAL", time.Minute),
+		ResendLimit:    utils.GetEnvInt("EMAIL_VERIFICATION_RESEND_LIMIT", 5),
+	}
+}
+
+// BlocksLogin reports whether the policy keeps a user from logging in
+func (s *EmailVerificationService) BlocksLogin(user *models.User) bool {
+	return s.Policy == VerificationPolicyLogin && !user.EmailVerified
+}
+
+// CanSendMessages reports whether the policy lets a user send messages
+func (s *EmailVerificationService) CanSendMessages(userID uint) (bool, error) {
+	if s.Policy == VerificationPolicyOff {
+		return true, nil
+	}
+	return s.Authz.IsEmailVerified(userID)
+}
+
+// SendVerification emails a verification link to a user's current address
+func (s *EmailVerificationService) SendVerification(user *models.User) error {
+	rawToken, err := s.Tokens.Issue(user.UserID, TokenPurposeEmailVerification, user.Email, s.TTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(`Hi %s,
+
+Please confirm that %s is your email address by opening this link within %s:
+
+%s/api/auth/verify?token=%s
+
+If you did not create a GinChat account, you can ignore this email.
+`, user.Username, user.Email, s.TTL, AppURL(), rawToken)
+
+	if err := s.Mailer.Send(user.Email, "Verify your GinChat email address", body); err != nil {
+		return errors.New("failed to send verification email")
+	}
+	return nil
+}
+
+// Resend emails a new verification link to the account with the given email.
+// It returns the user, or nil without an error if there is no such unverified
+// account, so callers can answer the same way whether or not the email is
+// registered. A user who asked too recently or too often gets no email.
+func (s *EmailVerificationService) Resend(email string) (*models.User, error) {
+	var user models.User
+	if result := s.DB.Where("email = ?", email).First(&user); result.Error != nil {
+		return nil, nil
+	}
+	if user.EmailVerified || user.Disabled {
+		return nil, nil
+	}
+
+	wait, err := s.Tokens.Throttle(user.UserID, TokenPurposeEmailVerification, s.ResendInterval, s.ResendLimit)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		return &user, errors.New("too many verification emails")
+	}
+
+	return &user, s.SendVerification(&user)
+}
+
+// Verify marks the email address a verification token was sent to as verified
+func (s *EmailVerificationService) Verify(rawToken string) (*models.User, error) {
+	token, err := s.Tokens.Consume(rawToken, TokenPurposeEmailVerification)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if result := s.DB.First(&user, token.UserID); result.Error != nil {
+		return nil, errors.New("user not found")
+	}
+
+	// A link sent to an address the user has since changed proves nothing
+	if user.Email != token.Data {
+		return nil, errors.New("invalid or expired token")
+	}
+
+	now := time.Now()
+	result := s.DB.Model(&user).Updates(map[string]interface{}{
+		"email_verified":    true,
+		"email_verified_at": now,
+	})
+	if result.Error != nil {
+		return nil, errors.New("failed to verify email")
+	}
+	s.Authz.Invalidate(user.UserID)
+
+	return &user, nil
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the MongoDB indexes the services rely on
+func EnsureIndexes(mongodb *mongo.Database) error {
+	ctx := context.Background()
+
+	// Messages are listed and counted per chatroom in _id order
+	_, err := mongodb.Collection("messages").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "chatroom_id", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// A client message ID identifies at most one message per sender and chatroom.
+	// Drop the earlier index that made it unique across all of a sender's chatrooms.
+	_, err = mongodb.Collection("messages").Indexes().DropOne(ctx, "sender_id_1_client_message_id_1")
+	if err != nil && !isIndexNotFound(err) {
+		return err
+	}
+	_, err = mongodb.Collection("messages").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "chatroom_id", Value: 1}, {Key: "sender_id", Value: 1}, {Key: "client_message_id", Value: 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"client_message_id": bson.M{"$type": "string"}}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// One read state per user and chatroom
+	_, err = mongodb.Collection("read_states").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "chatroom_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// isIndexNotFound reports whether dropping an index failed because it, or its collection, does not exist
+func isIndexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && (cmdErr.Code == 26 || cmdErr.Code == 27)
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+	"gorm.io/gorm"
+)
+
+// LoginBlock describes why login attempts are currently refused
+type LoginBlock struct {
+	// Locked is set when the account is locked out, rather than backing off
+	Locked bool
+	// RetryAfter is how long until the next attempt is accepted
+	RetryAfter time.Duration
+}
+
+// ipFailures tracks the failed logins from one IP address
+type ipFailures struct {
+	count int
+	last  time.Time
+}
+
+// LoginProtectionService slows down and locks out password guessing.
+// Failed logins are counted per account, on models.User, and per IP address,
+// per process. Past a number of free attempts every further attempt has to
+// wait exponentially longer; an account that reaches the lockout threshold
+// is locked until LockoutDuration passes, its password is reset or an admin
+// unlocks it.
+type LoginProtectionService struct {
+	DB *gorm.DB
+
+	// FreeAttempts is the number of failed logins per account before backoff starts
+	FreeAttempts int
+	// LockoutThreshold is the number of failed logins after which an account is locked
+	LockoutThreshold int
+	// LockoutDuration is how long a locked account stays locked
+	LockoutDuration time.Duration
+	// IPFreeAttempts is the number of failed logins per IP address before backoff starts
+	IPFreeAttempts int
+	// BackoffBase is the wait after the first failed attempt past the free ones
+	BackoffBase time.Duration
+	// BackoffMax caps the wait between attempts
+	BackoffMax time.Duration
+	// FailureWindow is how long failed logins are remembered after the last one
+	FailureWindow time.Duration
+
+	ips  map[string]*ipFailures
+	mux  sync.Mutex
+	stop chan struct{}
+}
+
+// NewLoginProtectionService creates a new LoginProtectionService
+func NewLoginProtectionService(db *gorm.DB) *LoginProtectionService {
+	return &LoginProtectionService{
+		DB:               db,
+		FreeAttempts:     utils.GetEnvInt("LOGIN_FREE_ATTEMPTS", 3),
+		LockoutThreshold: utils.GetEnvInt("LOGIN_LOCKOUT_THRESHOLD", 10),
+		LockoutDuration:  utils.GetEnvDuration("LOGIN_LOCKOUT_DURATION", 30*time.Minute),
+		IPFreeAttempts:   utils.GetEnvInt("LOGIN_IP_FREE_ATTEMPTS", 20),
+		BackoffBase:      utils.GetEnvDuration("LOGIN_BACKOFF_BASE", time.Second),
+		BackoffMax:       utils.GetEnvDuration("LOGIN_BACKOFF_MAX", 5*time.Minute),
+		FailureWindow:    utils.GetEnvDuration("LOGIN_FAILURE_WINDOW", time.Hour),
+		ips:              make(map[string]*ipFailures),
+		stop:             make(chan struct{}),
+	}
+}
+
+// Check returns why a login for an email from an IP address must be refused
+// right now, or nil if it may go ahead
+func (s *LoginProtectionService) Check(email, ip string) *LoginBlock {
+	now := time.Now()
+
+	var user models.User
+	if result := s.DB.Where("email = ?", email).First(&user); result.Error == nil {
+		if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+			return &LoginBlock{Locked: true, RetryAfter: user.LockedUntil.Sub(now)}
+		}
+		if user.LastFailedLoginAt != nil && now.Sub(*user.LastFailedLoginAt) < s.FailureWindow {
+			if wait := s.wait(user.FailedLoginAttempts, s.FreeAttempts, *user.LastFailedLoginAt, now); wait > 0 {
+				return &LoginBlock{RetryAfter: wait}
+			}
+		}
+	}
+
+	s.mux.Lock()
+	failures, ok := s.ips[ip]
+	var wait time.Duration
+	if ok {
+		wait = s.wait(failures.count, s.IPFreeAttempts, failures.last, now)
+	}
+	s.mux.Unlock()
+
+	if wait > 0 {
+		return &LoginBlock{RetryAfter: wait}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed login for an email from an IP address and
+// returns the account if this failure locked it
+func (s *LoginProtectionService) RecordFailure(email, ip string) *models.User {
+	now := time.Now()
+
+	s.mux.Lock()
+	failures, ok := s.ips[ip]
+	if !ok || now.Sub(failures.last) >= s.FailureWindow {
+		failures = &ipFailures{}
+		s.ips[ip] = failures
+	}
+	failures.count++
+	failures.last = now
+	s.mux.Unlock()
+
+	// The counter is only changed by single statements, so concurrent
+	// failures neither overwrite each other nor lock the account twice.
+	// Failures older than the window no longer count.
+	cutoff := now.Add(-s.FailureWindow)
+	if result := s.DB.Model(&models.User{}).
+		Where("email = ? AND (last_failed_login_at IS NULL OR last_failed_login_at <= ?)", email, cutoff).
+		Update("failed_login_attempts", 0); result.Error != nil {
+		return nil
+	}
+
+	result := s.DB.Model(&models.User{}).Where("email = ?", email).Updates(map[string]interface{}{
+		"failed_login_attempts": gorm.Expr("failed_login_attempts + 1"),
+		"last_failed_login_at":  now,
+	})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil
+	}
+
+	// Only the failure that reaches the threshold locks the account; the lock restarts the count
+	result = s.DB.Model(&models.User{}).
+		Where("email = ? AND failed_login_attempts >= ?", email, s.LockoutThreshold).
+		Updates(map[string]interface{}{
+			"locked_until":          now.Add(s.LockoutDuration),
+			"failed_login_attempts": 0,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil
+	}
+
+	var user models.User
+	if result := s.DB.Where("email = ?", email).First(&user); result.Error != nil {
+		return nil
+	}
+	return &user
+}
+
+// RecordSuccess clears the failed logins of an account after a successful login
+func (s *LoginProtectionService) RecordSuccess(user *models.User) {
+	if user.FailedLoginAttempts == 0 && user.LockedUntil == nil {
+		return
+	}
+
+	user.FailedLoginAttempts = 0
+	user.LockedUntil = nil
+	s.DB.Model(user).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+	})
+}
+
+// Unlock lifts a lockout and clears the failed logins of an account
+func (s *LoginProtectionService) Unlock(userID uint) (*models.User, error) {
+	var user models.User
+	if result := s.DB.First(&user, userID); result.Error != nil {
+		return nil, errors.New("user not found")
+	}
+
+	result := s.DB.Model(&user).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+	})
+	if result.Error != nil {
+		return nil, errors.New("failed to update user")
+	}
+
+	user.FailedLoginAttempts = 0
+	user.LockedUntil = nil
+	return &user, nil
+}
+
+// Start runs the sweeper that forgets IP addresses whose failures have expired
+func (s *LoginProtectionService) Start() {
+	go func() {
+		ticker := time.NewTicker(s.FailureWindow)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.sweep()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the sweeper
+func (s *LoginProtectionService) Stop() {
+	close(s.stop)
+}
+
+// sweep removes the IP addresses whose last failure is older than the failure window
+func (s *LoginProtectionService) sweep() {
+	cutoff := time.Now().Add(-s.FailureWindow)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for ip, failures := range s.ips {
+		if failures.last.Before(cutoff) {
+			delete(s.ips, ip)
+		}
+	}
+}
+
+// wait returns how long after the last failure the next attempt has to wait,
+// doubling with every failure past the free attempts
+func (s *LoginProtectionService) wait(failures, free int, last, now time.Time) time.Duration {
+	if failures < free {
+		return 0
+	}
+
+	delay := s.BackoffBase
+	for i := free; i < failures && delay < s.BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > s.BackoffMax {
+		delay = s.BackoffMax
+	}
+
+	return last.Add(delay).Sub(now)
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ginchat/utils"
+)
+
+// ipSends tracks the emails requested from one IP address in the current window
+type ipSends struct {
+	count int
+	start time.Time
+}
+
+// MailThrottle limits how many emails the unauthenticated endpoints send on
+// behalf of one IP address. Requests are counted per process, in fixed windows.
+type MailThrottle struct {
+	// IPLimit is the number of emails one IP address can request per window
+	IPLimit int
+	// Window is how long requests from an IP address are counted
+	Window time.Duration
+
+	ips  map[string]*ipSends
+	mux  sync.Mutex
+	stop chan struct{}
+}
+
+// NewMailThrottle creates a new MailThrottle
+func NewMailThrottle() *MailThrottle {
+	return &MailThrottle{
+		IPLimit: utils.GetEnvInt("MAIL_IP_LIMIT", 10),
+		Window:  utils.GetEnvDuration("MAIL_IP_WINDOW", time.Hour),
+		ips:     make(map[string]*ipSends),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Allow counts an email requested from an IP address. It returns zero if the
+// email may be sent, or how long until the IP address may request another one.
+func (t *MailThrottle) Allow(ip string) time.Duration {
+	now := time.Now()
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	sends, ok := t.ips[ip]
+	if !ok || now.Sub(sends.start) >= t.Window {
+		sends = &ipSends{start: now}
+		t.ips[ip] = sends
+	}
+	if sends.count >= t.IPLimit {
+		return sends.start.Add(t.Window).Sub(now)
+	}
+
+	sends.count++
+	return 0
+}
+
+// Start starts removing IP addresses whose window has ended
+func (t *MailThrottle) Start() {
+	go func() {
+		ticker := time.NewTicker(t.Window)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				t.sweep()
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the sweeper
+func (t *MailThrottle) Stop() {
+	close(t.stop)
+}
+
+// sweep removes the IP addresses whose window has ended
+func (t *MailThrottle) sweep() {
+	cutoff := time.Now().Add(-t.Window)
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for ip, sends := range t.ips {
+		if sends.start.Before(cutoff) {
+			delete(t.ips, ip)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Mailer sends plain-text emails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// NewMailer creates the mailer selected by the MAILER environment variable
+func NewMailer(logger *logrus.Logger) (Mailer, error) {
+	switch os.Getenv("MAILER") {
+	case "", "log":
+		return NewLogMailer(os.Getenv("MAIL_FILE"), logger), nil
+	case "smtp":
+		return NewSMTPMailer()
+	default:
+		return nil, errors.New("unknown mailer: " + os.Getenv("MAILER"))
+	}
+}
+
+// SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+// NewSMTPMailer creates an SMTPMailer from the SMTP_* environment variables
+func NewSMTPMailer() (*SMTPMailer, error) {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil, errors.New("SMTP_HOST environment variable not set")
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	mailer := &SMTPMailer{
+		Addr: net.JoinHostPort(host, port),
+		From: mailFrom(),
+	}
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		mailer.Auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	return mailer, nil
+}
+
+// Send sends an email
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	msg := strings.Join([]string{
+		"From: " + m.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	// The envelope sender is the bare address of the From header
+	sender := m.From
+	if address, err := mail.ParseAddress(m.From); err == nil {
+		sender = address.Address
+	}
+
+	if err := smtp.SendMail(m.Addr, m.Auth, sender, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// LogMailer logs that emails were sent and, if a path is set, appends them to
+// a file. The body holds live tokens, so it is only logged at debug level.
+// It is meant for local development and tests.
+type LogMailer struct {
+	Path   string
+	logger *logrus.Logger
+	mux    sync.Mutex
+}
+
+// NewLogMailer creates a new LogMailer
+func NewLogMailer(path string, logger *logrus.Logger) *LogMailer {
+	return &LogMailer{
+		Path:   path,
+		logger: logger,
+	}
+}
+
+// Send logs an email
+func (m *LogMailer) Send(to, subject, body string) error {
+	entry := m.logger.WithFields(logrus.Fields{
+		"to":      to,
+		"subject": subject,
+	})
+	entry.Info("Email sent")
+	entry.Debug(body)
+
+	if m.Path == "" {
+		return nil
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	file, err := os.OpenFile(m.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "From: %s\nTo: %s\nSubject: %s\nDate: %s\n\n%s\n\n", mailFrom(), to, subject, time.Now().Format(time.RFC1123Z), body)
+	return err
+}
+
+// mailFrom returns the sender address of outgoing emails
+func mailFrom() string {
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		return from
+	}
+	return "GinChat <no-reply@ginchat.local>"
+}
+
+// AppURL returns the frontend base URL used in links sent by email
+func AppURL() string {
+	if url := os.Getenv("APP_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return "http://localhost:3000"
+}
@@ -1,8 +1,10 @@
 package services
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/ginchat/models"
@@ -16,6 +18,7 @@ import (
 type MessageService struct {
 	MongoDB  *mongo.Database
 	MsgColl  *mongo.Collection
+	ReadColl *mongo.Collection
 	ChatSvc  *ChatroomService
 }
 
@@ -24,41 +27,117 @@ func NewMessageService(mongodb *mongo.Database, chatroomService *ChatroomService
 	return &MessageService{
 		MongoDB:  mongodb,
 		MsgColl:  mongodb.Collection("messages"),
+		ReadColl: mongodb.Collection("read_states"),
 		ChatSvc:  chatroomService,
 	}
 }
 
-// SendMessage sends a message to a chatroom
-func (s *MessageService) SendMessage(chatroomID primitive.ObjectID, userID uint, username string, messageType, textContent, mediaURL string) (*models.Message, error) {
+// maxTextContentLength is the maximum number of characters allowed in a message's text
+const maxTextContentLength = 4000
+
+// maxClientMessageIDLength is the maximum length of a client-generated message ID
+const maxClientMessageIDLength = 64
+
+// messageTypeContent lists the supported message types and whether each requires text and media
+var messageTypeContent = map[string]struct{ text, media bool }{
+	"text":             {text: true},
+	"picture":          {media: true},
+	"audio":            {media: true},
+	"video":            {media: true},
+	"text_and_picture": {text: true, media: true},
+	"text_and_audio":   {text: true, media: true},
+	"text_and_video":   {text: true, media: true},
+}
+
+// ValidateMessage checks that a message's content matches its type
+func (s *MessageService) ValidateMessage(messageType, textContent, mediaURL string) error {
+	content, ok := messageTypeContent[messageType]
+	if !ok {
+		return errors.New("invalid message: unsupported message type")
+	}
+
+	if content.text && strings.TrimSpace(textContent) == "" {
+		return errors.New("invalid message: text content is required")
+	}
+	if len([]rune(textContent)) > maxTextContentLength {
+		return errors.New("invalid message: text content is too long")
+	}
+	if content.media && strings.TrimSpace(mediaURL) == "" {
+		return errors.New("invalid message: media URL is required")
+	}
+
+	return nil
+}
+
+// SendMessage sends a message to a chatroom.
+// When a client message ID is given, a retry of an already stored message returns
+// the stored message instead of a duplicate, and the returned bool is false.
+func (s *MessageService) SendMessage(chatroomID primitive.ObjectID, userID uint, username string, messageType, textContent, mediaURL, clientMessageID string) (*models.Message, bool, error) {
+	// Validate the message content
+	if err := s.ValidateMessage(messageType, textContent, mediaURL); err != nil {
+		return nil, false, err
+	}
+	if len(clientMessageID) > maxClientMessageIDLength {
+		return nil, false, errors.New("invalid message: client message ID is too long")
+	}
+
 	// Check if chatroom exists and user is a member
 	chatroom, err := s.ChatSvc.GetChatroomByID(chatroomID)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	// Check if user is a member of the chatroom
 	if !s.ChatSvc.IsMember(chatroom, userID) {
-		return nil, errors.New("user is not a member of this chatroom")
+		return nil, false, errors.New("user is not a member of this chatroom")
+	}
+
+	// Return the stored message if this is a retry
+	if clientMessageID != "" {
+		if existing, err := s.findByClientMessageID(chatroomID, userID, clientMessageID); err == nil {
+			return existing, false, nil
+		}
 	}
 
 	// Create new message
 	message := models.Message{
-		ID:          primitive.NewObjectID(),
-		ChatroomID:  chatroomID,
-		SenderID:    userID,
-		SenderName:  username,
-		MessageType: messageType,
-		TextContent: textContent,
-		MediaURL:    mediaURL,
-		SentAt:      time.Now(),
+		ID:              primitive.NewObjectID(),
+		ChatroomID:      chatroomID,
+		SenderID:        userID,
+		SenderName:      username,
+		MessageType:     messageType,
+		TextContent:     textContent,
+		MediaURL:        mediaURL,
+		SentAt:          time.Now(),
+		ClientMessageID: clientMessageID,
 	}
 
 	// Save message to MongoDB
 	_, err = s.MsgColl.InsertOne(context.Background(), message)
 	if err != nil {
-		return nil, errors.New("failed to send message")
+		// A concurrent retry stored the message first
+		if clientMessageID != "" && mongo.IsDuplicateKeyError(err) {
+			if existing, err := s.findByClientMessageID(chatroomID, userID, clientMessageID); err == nil {
+				return existing, false, nil
+			}
+		}
+		return nil, false, errors.New("failed to send message")
 	}
 
+	return &message, true, nil
+}
+
+// findByClientMessageID retrieves a message by its chatroom, sender and client-generated ID
+func (s *MessageService) findByClientMessageID(chatroomID primitive.ObjectID, userID uint, clientMessageID string) (*models.Message, error) {
+	var message models.Message
+	err := s.MsgColl.FindOne(context.Background(), bson.M{
+		"chatroom_id":       chatroomID,
+		"sender_id":         userID,
+		"client_message_id": clientMessageID,
+	}).Decode(&message)
+	if err != nil {
+		return nil, errors.New("message not found")
+	}
 	return &message, nil
 }
 
@@ -97,43 +176,102 @@ func (s *MessageService) GetMessages(chatroomID primitive.ObjectID, userID uint,
 	return messages, nil
 }
 
-// DeleteMessage deletes a message
-func (s *MessageService) DeleteMessage(messageID primitive.ObjectID, userID uint) error {
+// GetMessagesSince retrieves, oldest first, the messages of a chatroom newer than a given message.
+// If more than limit messages are newer, none are returned and the bool is false.
+func (s *MessageService) GetMessagesSince(chatroomID primitive.ObjectID, userID uint, afterID primitive.ObjectID, limit int) ([]models.Message, bool, error) {
+	// Check if chatroom exists and user is a member
+	chatroom, err := s.ChatSvc.GetChatroomByID(chatroomID)
+	if err != nil {
+		return nil, false, err
+	}
+	if !s.ChatSvc.IsMember(chatroom, userID) {
+		return nil, false, errors.New("user is not a member of this chatroom")
+	}
+
+	// Fetch one more than the limit to detect a client that is too far behind
+	findOptions := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(limit) + 1)
+	cursor, err := s.MsgColl.Find(context.Background(), bson.M{
+		"chatroom_id": chatroomID,
+		"_id":         bson.M{"$gt": afterID},
+	}, findOptions)
+	if err != nil {
+		return nil, false, errors.New("failed to get messages")
+	}
+	defer cursor.Close(context.Background())
+
+	var messages []models.Message
+	if err := cursor.All(context.Background(), &messages); err != nil {
+		return nil, false, errors.New("failed to decode messages")
+	}
+
+	if len(messages) > limit {
+		return nil, false, nil
+	}
+	return messages, true, nil
+}
+
+// DeleteMessage deletes a message from a chatroom and returns it.
+// Only the sender can delete a message, unless moderator is set.
+func (s *MessageService) DeleteMessage(chatroomID, messageID primitive.ObjectID, userID uint, moderator bool) (*models.Message, error) {
+	// Check if chatroom exists and user is a member
+	chatroom, err := s.ChatSvc.GetChatroomByID(chatroomID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.ChatSvc.IsMember(chatroom, userID) {
+		return nil, errors.New("user is not a member of this chatroom")
+	}
+
 	// Find the message
 	var message models.Message
-	err := s.MsgColl.FindOne(context.Background(), bson.M{"_id": messageID}).Decode(&message)
+	err = s.MsgColl.FindOne(context.Background(), bson.M{"_id": messageID, "chatroom_id": chatroomID}).Decode(&message)
 	if err != nil {
-		return errors.New("message not found")
+		return nil, errors.New("message not found")
 	}
 
 	// Check if the user is the sender of the message
-	if message.SenderID != userID {
-		return errors.New("user is not the sender of this message")
+	if message.SenderID != userID && !moderator {
+		return nil, errors.New("user is not the sender of this message")
 	}
 
 	// Delete the message
 	_, err = s.MsgColl.DeleteOne(context.Background(), bson.M{"_id": messageID})
 	if err != nil {
-		return errors.New("failed to delete message")
+		return nil, errors.New("failed to delete message")
 	}
 
-	return nil
+	return &message, nil
 }
 
-// EditMessage edits a message
-func (s *MessageService) EditMessage(messageID primitive.ObjectID, userID uint, textContent string) (*models.Message, error) {
+// EditMessage replaces the text of a message in a chatroom.
+// Only the sender can edit a message, unless moderator is set.
+func (s *MessageService) EditMessage(chatroomID, messageID primitive.ObjectID, userID uint, textContent string, moderator bool) (*models.Message, error) {
+	// Check if chatroom exists and user is a member
+	chatroom, err := s.ChatSvc.GetChatroomByID(chatroomID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.ChatSvc.IsMember(chatroom, userID) {
+		return nil, errors.New("user is not a member of this chatroom")
+	}
+
 	// Find the message
 	var message models.Message
-	err := s.MsgColl.FindOne(context.Background(), bson.M{"_id": messageID}).Decode(&message)
+	err = s.MsgColl.FindOne(context.Background(), bson.M{"_id": messageID, "chatroom_id": chatroomID}).Decode(&message)
 	if err != nil {
 		return nil, errors.New("message not found")
 	}
 
 	// Check if the user is the sender of the message
-	if message.SenderID != userID {
+	if message.SenderID != userID && !moderator {
 		return nil, errors.New("user is not the sender of this message")
 	}
 
+	// The new text must satisfy the same rules as when the message was sent
+	if err := s.ValidateMessage(message.MessageType, textContent, message.MediaURL); err != nil {
+		return nil, err
+	}
+
 	// Update the message
 	_, err = s.MsgColl.UpdateOne(
 		context.Background(),
@@ -158,3 +296,125 @@ func (s *MessageService) EditMessage(messageID primitive.ObjectID, userID uint,
 
 	return &message, nil
 }
+
+// MarkRead moves a user's read position in a chatroom forward to a message.
+// It reports whether the position changed; marking the current or an older message is a no-op.
+func (s *MessageService) MarkRead(chatroomID primitive.ObjectID, userID uint, username string, messageID primitive.ObjectID) (*models.ReadState, bool, error) {
+	// Check if chatroom exists and user is a member
+	chatroom, err := s.ChatSvc.GetChatroomByID(chatroomID)
+	if err != nil {
+		return nil, false, err
+	}
+	if !s.ChatSvc.IsMember(chatroom, userID) {
+		return nil, false, errors.New("user is not a member of this chatroom")
+	}
+
+	// Check that the message belongs to the chatroom
+	count, err := s.MsgColl.CountDocuments(context.Background(), bson.M{"_id": messageID, "chatroom_id": chatroomID})
+	if err != nil {
+		return nil, false, errors.New("failed to find message")
+	}
+	if count == 0 {
+		return nil, false, errors.New("message not found")
+	}
+
+	// Only ever move the read position forward; the previous state tells whether it moved
+	var previous models.ReadState
+	err = s.ReadColl.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"chatroom_id": chatroomID, "user_id": userID},
+		bson.M{
+			"$max": bson.M{"last_read_message_id": messageID},
+			"$set": bson.M{"username": username},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before),
+	).Decode(&previous)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, false, errors.New("failed to mark messages as read")
+	}
+
+	state := previous
+	state.ChatroomID = chatroomID
+	state.UserID = userID
+	state.Username = username
+	if err == nil && !previous.LastReadMessageID.IsZero() && bytes.Compare(previous.LastReadMessageID[:], messageID[:]) >= 0 {
+		return &state, false, nil
+	}
+
+	// The read time only changes with the position, unless a newer read has already moved it on
+	state.LastReadMessageID = messageID
+	state.ReadAt = time.Now()
+	_, err = s.ReadColl.UpdateOne(
+		context.Background(),
+		bson.M{"chatroom_id": chatroomID, "user_id": userID, "last_read_message_id": messageID},
+		bson.M{"$set": bson.M{"read_at": state.ReadAt}},
+	)
+	if err != nil {
+		return nil, false, errors.New("failed to mark messages as read")
+	}
+
+	return &state, true, nil
+}
+
+// GetReadStates retrieves the read positions of all users in a chatroom
+func (s *MessageService) GetReadStates(chatroomID primitive.ObjectID) ([]models.ReadState, error) {
+	cursor, err := s.ReadColl.Find(context.Background(), bson.M{"chatroom_id": chatroomID})
+	if err != nil {
+		return nil, errors.New("failed to get read states")
+	}
+	defer cursor.Close(context.Background())
+
+	var states []models.ReadState
+	if err := cursor.All(context.Background(), &states); err != nil {
+		return nil, errors.New("failed to decode read states")
+	}
+
+	return states, nil
+}
+
+// GetUnreadCounts counts, per chatroom, the messages from other users that a user has not read yet
+func (s *MessageService) GetUnreadCounts(userID uint, chatroomIDs []primitive.ObjectID) (map[primitive.ObjectID]int64, error) {
+	counts := make(map[primitive.ObjectID]int64, len(chatroomIDs))
+	if len(chatroomIDs) == 0 {
+		return counts, nil
+	}
+
+	// Load the user's read positions for these chatrooms
+	cursor, err := s.ReadColl.Find(context.Background(), bson.M{
+		"user_id":     userID,
+		"chatroom_id": bson.M{"$in": chatroomIDs},
+	})
+	if err != nil {
+		return nil, errors.New("failed to get read states")
+	}
+	defer cursor.Close(context.Background())
+
+	var states []models.ReadState
+	if err := cursor.All(context.Background(), &states); err != nil {
+		return nil, errors.New("failed to decode read states")
+	}
+
+	lastRead := make(map[primitive.ObjectID]primitive.ObjectID, len(states))
+	for _, state := range states {
+		lastRead[state.ChatroomID] = state.LastReadMessageID
+	}
+
+	// Count the newer messages sent by others
+	for _, chatroomID := range chatroomIDs {
+		filter := bson.M{
+			"chatroom_id": chatroomID,
+			"sender_id":   bson.M{"$ne": userID},
+		}
+		if last, ok := lastRead[chatroomID]; ok {
+			filter["_id"] = bson.M{"$gt": last}
+		}
+
+		count, err := s.MsgColl.CountDocuments(context.Background(), filter)
+		if err != nil {
+			return nil, errors.New("failed to count unread messages")
+		}
+		counts[chatroomID] = count
+	}
+
+	return counts, nil
+}
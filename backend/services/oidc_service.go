@@ -0,0 +1,685 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// usernameInvalidChars matches the characters dropped from generated usernames
+var usernameInvalidChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// OIDCProvider is an OpenID Connect identity provider users can log in with,
+// configured from OIDC_<NAME>_* environment variables
+type OIDCProvider struct {
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	discovery   *oidcDiscovery
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+	mux         sync.Mutex
+}
+
+// OIDCProviderInfo describes a provider to the login page
+type OIDCProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+// oidcDiscovery is the part of a provider's discovery document the login flow uses
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcTokenResponse is a provider's answer to an authorization code exchange
+type oidcTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// oidcClaims are the identity claims of an external account
+type oidcClaims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// OIDCService logs users in with external OpenID Connect providers using the
+// authorization code flow with PKCE. External accounts are linked to users
+// through identities; a user is created on the first login of an unknown account.
+type OIDCService struct {
+	DB      *gorm.DB
+	UserSvc *UserService
+	Tokens  *UserTokenService
+
+	// Providers are the configured providers by name
+	Providers map[string]*OIDCProvider
+	// CallbackBaseURL is the public URL providers redirect back to, under /api/auth/oidc/<name>/callback
+	CallbackBaseURL string
+	// StateTTL is how long a user has to complete a login at the provider
+	StateTTL time.Duration
+	// LoginCodeTTL is how long the frontend has to exchange a completed login for tokens
+	LoginCodeTTL time.Duration
+
+	order  []string
+	client *http.Client
+}
+
+// NewOIDCService creates a new OIDCService with the providers listed in OIDC_PROVIDERS
+func NewOIDCService(db *gorm.DB, userService *UserService, userTokenService *UserTokenService) *OIDCService {
+	callbackBaseURL := strings.TrimSuffix(os.Getenv("OIDC_CALLBACK_BASE_URL"), "/")
+	if callbackBaseURL == "" {
+		// The frontend proxies /api to the backend, so the flow stays on one origin
+		callbackBaseURL = AppURL()
+	}
+
+	s := &OIDCService{
+		DB:              db,
+		UserSvc:         userService,
+		Tokens:          userTokenService,
+		Providers:       make(map[string]*OIDCProvider),
+		CallbackBaseURL: callbackBaseURL,
+		StateTTL:        utils.GetEnvDuration("OIDC_STATE_TTL", 10*time.Minute),
+		LoginCodeTTL:    utils.GetEnvDuration("OIDC_LOGIN_CODE_TTL", time.Minute),
+		client:          &http.Client{Timeout: 10 * time.Second},
+	}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := &OIDCProvider{
+			Name:         name,
+			DisplayName:  os.Getenv(prefix + "DISPLAY_NAME"),
+			Issuer:       strings.TrimSuffix(os.Getenv(prefix+"ISSUER"), "/"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			logrus.Warnf("OIDC provider %s is missing %sISSUER or %sCLIENT_ID, skipping it", name, prefix, prefix)
+			continue
+		}
+		if provider.DisplayName == "" {
+			provider.DisplayName = name
+		}
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = []string{"openid", "email", "profile"}
+		}
+
+		s.Providers[name] = provider
+		s.order = append(s.order, name)
+	}
+
+	return s
+}
+
+// ProviderList returns the configured providers in configuration order
+func (s *OIDCService) ProviderList() []OIDCProviderInfo {
+	providers := make([]OIDCProviderInfo, 0, len(s.order))
+	for _, name := range s.order {
+		providers = append(providers, OIDCProviderInfo{
+			Name:        name,
+			DisplayName: s.Providers[name].DisplayName,
+		})
+	}
+	return providers
+}
+
+// StartLogin stores a new login state and returns the provider's authorization
+// URL to send the user to, and the state the callback must present
+func (s *OIDCService) StartLogin(providerName string) (string, string, error) {
+	provider, ok := s.Providers[providerName]
+	if !ok {
+		return "", "", errors.New("unknown provider")
+	}
+
+	discovery, err := s.discover(provider)
+	if err != nil {
+		logrus.Errorf("OIDC discovery for %s failed: %v", provider.Name, err)
+		return "", "", errors.New("provider unavailable")
+	}
+
+	state, errState := randomToken(32)
+	nonce, errNonce := randomToken(32)
+	verifier, errVerifier := randomToken(32)
+	if errState != nil || errNonce != nil || errVerifier != nil {
+		return "", "", errors.New("failed to generate login state")
+	}
+
+	// Drop abandoned logins while storing the new one
+	now := time.Now()
+	s.DB.Where("expires_at < ?", now).Delete(&models.OIDCLoginState{})
+	result := s.DB.Create(&models.OIDCLoginState{
+		StateHash:    hashToken(state),
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    now.Add(s.StateTTL),
+		CreatedAt:    now,
+	})
+	if result.Error != nil {
+		return "", "", errors.New("failed to store login state")
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", provider.ClientID)
+	params.Set("redirect_uri", s.callbackURL(provider))
+	params.Set("scope", strings.Join(provider.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), state, nil
+}
+
+// CompleteLogin exchanges the authorization code a provider redirected back
+// with, verifies the ID token and returns the user linked to the external
+// account, creating the user on first login. It also reports whether the
+// external account was linked by this login.
+func (s *OIDCService) CompleteLogin(providerName, state, code string) (*models.User, bool, error) {
+	provider, ok := s.Providers[providerName]
+	if !ok {
+		return nil, false, errors.New("unknown provider")
+	}
+
+	// A state can be used once, with the provider it was issued for
+	var loginState models.OIDCLoginState
+	result := s.DB.Where("state_hash = ? AND provider = ?", hashToken(state), provider.Name).First(&loginState)
+	if result.Error != nil || time.Now().After(loginState.ExpiresAt) {
+		return nil, false, errors.New("invalid login state")
+	}
+	result = s.DB.Where("state_hash = ?", loginState.StateHash).Delete(&models.OIDCLoginState{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, false, errors.New("invalid login state")
+	}
+
+	tokens, err := s.exchangeCode(provider, code, loginState.CodeVerifier)
+	if err != nil {
+		logrus.Warnf("OIDC code exchange with %s failed: %v", provider.Name, err)
+		return nil, false, errors.New("login failed")
+	}
+
+	claims, err := s.verifyIDToken(provider, tokens.IDToken, loginState.Nonce)
+	if err != nil {
+		logrus.Warnf("OIDC ID token from %s rejected: %v", provider.Name, err)
+		return nil, false, errors.New("login failed")
+	}
+
+	// Some providers only return the email from the userinfo endpoint
+	if claims.Email == "" && tokens.AccessToken != "" {
+		if err := s.fetchUserinfo(provider, tokens.AccessToken, claims); err != nil {
+			logrus.Warnf("OIDC userinfo from %s failed: %v", provider.Name, err)
+		}
+	}
+
+	return s.linkUser(provider.Name, claims)
+}
+
+// IssueLoginCode returns a short-lived, single-use code the frontend exchanges
+// for the user's tokens, so tokens never travel in a redirect URL
+func (s *OIDCService) IssueLoginCode(user *models.User) (string, error) {
+	return s.Tokens.Issue(user.UserID, TokenPurposeOIDCLogin, "", s.LoginCodeTTL)
+}
+
+// RedeemLoginCode returns the user a login code was issued to
+func (s *OIDCService) RedeemLoginCode(code string) (*models.User, error) {
+	token, err := s.Tokens.Consume(code, TokenPurposeOIDCLogin)
+	if err != nil {
+		return nil, err
+	}
+	return s.UserSvc.GetUserByID(token.UserID)
+}
+
+// linkUser returns the user linked to an external account. An unknown account
+// is linked to the user with the same email if both sides have verified it,
+// or else to a new user.
+func (s *OIDCService) linkUser(providerName string, claims *oidcClaims) (*models.User, bool, error) {
+	now := time.Now()
+
+	var identity models.Identity
+	if result := s.DB.Where("provider = ? AND subject = ?", providerName, claims.Subject).First(&identity); result.Error == nil {
+		user, err := s.UserSvc.GetUserByID(identity.UserID)
+		if err != nil {
+			return nil, false, err
+		}
+		s.DB.Model(&identity).Updates(map[string]interface{}{
+			"email":         claims.Email,
+			"last_login_at": now,
+		})
+		return user, false, nil
+	}
+
+	if claims.Email == "" {
+		return nil, false, errors.New("email required")
+	}
+
+	var user models.User
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Where("email = ?", claims.Email).First(&user); result.Error == nil {
+			// Linking on an unverified address would hand the account to whoever claimed it first
+			if !claims.EmailVerified || !user.EmailVerified {
+				return errors.New("email already registered")
+			}
+		} else {
+			created, err := s.createUser(tx, claims)
+			if err != nil {
+				return err
+			}
+			user = *created
+		}
+
+		return tx.Create(&models.Identity{
+			UserID:      user.UserID,
+			Provider:    providerName,
+			Subject:     claims.Subject,
+			Email:       claims.Email,
+			LastLoginAt: &now,
+			CreatedAt:   now,
+		}).Error
+	})
+	if err != nil {
+		if err.Error() == "email already registered" {
+			return nil, false, err
+		}
+		return nil, false, errors.New("failed to link account")
+	}
+
+	return &user, true, nil
+}
+
+// createUser creates a user for an external account, with a generated unique
+// username and a random password the user can replace through a password reset
+func (s *OIDCService) createUser(tx *gorm.DB, claims *oidcClaims) (*models.User, error) {
+	localPart, _, _ := strings.Cut(claims.Email, "@")
+	username, err := s.uniqueUsername(tx, claims.PreferredUsername, claims.Name, localPart)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := s.UserSvc.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	user := models.User{
+		Username:      username,
+		Email:         claims.Email,
+		Password:      hashedPassword,
+		Role:          RoleMember,
+		Status:        "offline",
+		EmailVerified: claims.EmailVerified,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if claims.EmailVerified {
+		user.EmailVerifiedAt = &now
+	}
+
+	if result := tx.Create(&user); result.Error != nil {
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
+// uniqueUsername derives a username from the first usable candidate, adding a
+// number if it is taken
+func (s *OIDCService) uniqueUsername(tx *gorm.DB, candidates ...string) (string, error) {
+	base := "user"
+	for _, candidate := range candidates {
+		candidate = strings.ToLower(strings.Join(strings.Fields(candidate), "_"))
+		candidate = usernameInvalidChars.ReplaceAllString(candidate, "")
+		if len(candidate) >= 3 {
+			base = candidate
+			break
+		}
+	}
+	if len(base) > 40 {
+		base = base[:40]
+	}
+
+	for i := 1; i <= 100; i++ {
+		username := base
+		if i > 1 {
+			username = fmt.Sprintf("%s%d", base, i)
+		}
+
+		var count int64
+		if result := tx.Model(&models.User{}).Where("username = ?", username).Count(&count); result.Error != nil {
+			return "", result.Error
+		}
+		if count == 0 {
+			return username, nil
+		}
+	}
+
+	// Fall back to a random suffix for very common names
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return base + "_" + hex.EncodeToString(suffix), nil
+}
+
+// callbackURL returns the redirect URI registered with a provider
+func (s *OIDCService) callbackURL(provider *OIDCProvider) string {
+	return s.CallbackBaseURL + "/api/auth/oidc/" + provider.Name + "/callback"
+}
+
+// discover fetches and caches a provider's discovery document
+func (s *OIDCService) discover(provider *OIDCProvider) (*oidcDiscovery, error) {
+	provider.mux.Lock()
+	defer provider.mux.Unlock()
+
+	if provider.discovery != nil {
+		return provider.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := s.getJSON(provider.Issuer+"/.well-known/openid-configuration", "", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != provider.Issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q", discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+
+	provider.discovery = &discovery
+	return provider.discovery, nil
+}
+
+// providerKey returns a provider's signing key by ID, refetching the key set
+// when the key is unknown, at most once a minute
+func (s *OIDCService) providerKey(provider *OIDCProvider, kid string) (crypto.PublicKey, error) {
+	discovery, err := s.discover(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	provider.mux.Lock()
+	defer provider.mux.Unlock()
+
+	lookup := func() crypto.PublicKey {
+		if kid == "" && len(provider.keys) == 1 {
+			for _, key := range provider.keys {
+				return key
+			}
+		}
+		return provider.keys[kid]
+	}
+
+	if key := lookup(); key != nil {
+		return key, nil
+	}
+	if time.Since(provider.keysFetched) < time.Minute {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set utils.JSONWebKeySet
+	if err := s.getJSON(discovery.JWKSURI, "", &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	provider.keys = keys
+	provider.keysFetched = time.Now()
+
+	if key := lookup(); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// exchangeCode redeems an authorization code at the provider's token endpoint
+func (s *OIDCService) exchangeCode(provider *OIDCProvider, code, verifier string) (*oidcTokenResponse, error) {
+	discovery, err := s.discover(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", s.callbackURL(provider))
+	form.Set("client_id", provider.ClientID)
+	form.Set("code_verifier", verifier)
+	if provider.ClientSecret != "" {
+		form.Set("client_secret", provider.ClientSecret)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tokens oidcTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("invalid token response (status %d)", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no ID token")
+	}
+	return &tokens, nil
+}
+
+// verifyIDToken checks an ID token's signature, issuer, audience, expiry and
+// nonce, and returns its identity claims
+func (s *OIDCService) verifyIDToken(provider *OIDCProvider, idToken, nonce string) (*oidcClaims, error) {
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := s.providerKey(provider, kid)
+		if err != nil {
+			return nil, err
+		}
+
+		// The key type must match the algorithm, so a public key is never used as an HMAC secret
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			if rsaKey, ok := key.(*rsa.PublicKey); ok {
+				return rsaKey, nil
+			}
+		case *jwt.SigningMethodECDSA:
+			if ecKey, ok := key.(*ecdsa.PublicKey); ok {
+				return ecKey, nil
+			}
+		default:
+			if token.Method == utils.SigningMethodEdDSA {
+				if edKey, ok := key.(ed25519.PublicKey); ok {
+					return edKey, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	discovery, err := s.discover(provider)
+	if err != nil {
+		return nil, err
+	}
+	if issuer, _ := claims["iss"].(string); issuer != discovery.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", issuer)
+	}
+	if !audienceContains(claims["aud"], provider.ClientID) {
+		return nil, errors.New("token is not issued to this client")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, errors.New("nonce mismatch")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("token has no expiry")
+	}
+
+	identity := &oidcClaims{}
+	readOIDCClaims(claims, identity)
+	if identity.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return identity, nil
+}
+
+// fetchUserinfo fills missing claims from the provider's userinfo endpoint
+func (s *OIDCService) fetchUserinfo(provider *OIDCProvider, accessToken string, claims *oidcClaims) error {
+	discovery, err := s.discover(provider)
+	if err != nil {
+		return err
+	}
+	if discovery.UserinfoEndpoint == "" {
+		return errors.New("provider has no userinfo endpoint")
+	}
+
+	var userinfo map[string]interface{}
+	if err := s.getJSON(discovery.UserinfoEndpoint, accessToken, &userinfo); err != nil {
+		return err
+	}
+
+	// Userinfo claims only count for the account the ID token was issued for
+	var info oidcClaims
+	readOIDCClaims(userinfo, &info)
+	if info.Subject != claims.Subject {
+		return errors.New("userinfo subject does not match the ID token")
+	}
+
+	claims.Email = info.Email
+	claims.EmailVerified = info.EmailVerified
+	if claims.Name == "" {
+		claims.Name = info.Name
+	}
+	if claims.PreferredUsername == "" {
+		claims.PreferredUsername = info.PreferredUsername
+	}
+	return nil
+}
+
+// getJSON fetches and decodes a JSON document, with an optional bearer token
+func (s *OIDCService) getJSON(endpoint, bearer string, target interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+// readOIDCClaims copies the identity claims out of an ID token or userinfo response
+func readOIDCClaims(source map[string]interface{}, claims *oidcClaims) {
+	claims.Subject, _ = source["sub"].(string)
+	claims.Email, _ = source["email"].(string)
+	claims.Name, _ = source["name"].(string)
+	claims.PreferredUsername, _ = source["preferred_username"].(string)
+
+	// Some providers send email_verified as a string
+	switch verified := source["email_verified"].(type) {
+	case bool:
+		claims.EmailVerified = verified
+	case string:
+		claims.EmailVerified = verified == "true"
+	}
+}
+
+// audienceContains reports whether an aud claim, a string or a list, includes a client ID
+func audienceContains(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, entry := range aud {
+			if entry == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
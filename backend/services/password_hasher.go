@@ -0,0 +1,233 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ginchat/utils"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms
+const (
+	HashAlgorithmArgon2id = "argon2id"
+	HashAlgorithmBcrypt   = "bcrypt"
+)
+
+// PasswordHasher hashes passwords with one algorithm. Hashes carry their
+// algorithm and parameters, so a hash stays verifiable after the
+// configuration changes and can be recognised as outdated.
+type PasswordHasher interface {
+	// Algorithm returns the name of the algorithm
+	Algorithm() string
+	// Hash hashes a password with the current parameters
+	Hash(password string) (string, error)
+	// Recognizes reports whether a hash was produced by this algorithm
+	Recognizes(hash string) bool
+	// Verify checks a password against a hash produced by this algorithm
+	Verify(hash, password string) bool
+	// Outdated reports whether a hash was produced with other parameters than the current ones
+	Outdated(hash string) bool
+}
+
+// PasswordHashers hashes new passwords with the configured algorithm and
+// verifies hashes of every supported algorithm
+type PasswordHashers struct {
+	Current   PasswordHasher
+	Supported []PasswordHasher
+}
+
+// NewPasswordHashers creates the password hashers from the environment
+func NewPasswordHashers() (*PasswordHashers, error) {
+	argon := &Argon2idHasher{
+		Memory:      uint32(utils.GetEnvInt("ARGON2_MEMORY", 19*1024)),
+		Iterations:  uint32(utils.GetEnvInt("ARGON2_ITERATIONS", 2)),
+		Parallelism: uint8(utils.GetEnvInt("ARGON2_PARALLELISM", 1)),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+	bcryptHasher := &BcryptHasher{
+		Cost: utils.GetEnvInt("BCRYPT_COST", 12),
+	}
+
+	hashers := &PasswordHashers{
+		Supported: []PasswordHasher{argon, bcryptHasher},
+	}
+
+	switch algorithm := os.Getenv("PASSWORD_HASH_ALGORITHM"); algorithm {
+	case "", HashAlgorithmArgon2id:
+		hashers.Current = argon
+	case HashAlgorithmBcrypt:
+		hashers.Current = bcryptHasher
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm %q", algorithm)
+	}
+	return hashers, nil
+}
+
+// Hash hashes a password with the current algorithm
+func (h *PasswordHashers) Hash(password string) (string, error) {
+	return h.Current.Hash(password)
+}
+
+// Verify checks a password against a hash of any supported algorithm, and
+// reports whether the hash should be replaced with a hash by the current
+// algorithm and parameters
+func (h *PasswordHashers) Verify(hash, password string) (bool, bool) {
+	hasher := h.hasherFor(hash)
+	if hasher == nil || !hasher.Verify(hash, password) {
+		return false, false
+	}
+	return true, hasher != h.Current || hasher.Outdated(hash)
+}
+
+// IsHashed reports whether a value is a hash of any supported algorithm
+func (h *PasswordHashers) IsHashed(value string) bool {
+	return h.hasherFor(value) != nil
+}
+
+// hasherFor returns the hasher that produced a hash, or nil
+func (h *PasswordHashers) hasherFor(hash string) PasswordHasher {
+	for _, hasher := range h.Supported {
+		if hasher.Recognizes(hash) {
+			return hasher
+		}
+	}
+	return nil
+}
+
+// Argon2idHasher hashes passwords with argon2id, encoded in the PHC string
+// format: $argon2id$v=19$m=<KiB>,t=<iterations>,p=<parallelism>$<salt>$<key>
+type Argon2idHasher struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// argon2idHash is a decoded argon2id hash
+type argon2idHash struct {
+	version     int
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+// Algorithm returns the name of the algorithm
+func (h *Argon2idHasher) Algorithm() string {
+	return HashAlgorithmArgon2id
+}
+
+// Hash hashes a password with the current parameters
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Recognizes reports whether a hash was produced by argon2id
+func (h *Argon2idHasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+// Verify checks a password against an argon2id hash
+func (h *Argon2idHasher) Verify(hash, password string) bool {
+	decoded, err := decodeArgon2id(hash)
+	if err != nil || decoded.version != argon2.Version {
+		return false
+	}
+
+	key := argon2.IDKey([]byte(password), decoded.salt, decoded.iterations, decoded.memory, decoded.parallelism, uint32(len(decoded.key)))
+	return subtle.ConstantTimeCompare(key, decoded.key) == 1
+}
+
+// Outdated reports whether a hash was produced with other parameters than the current ones
+func (h *Argon2idHasher) Outdated(hash string) bool {
+	decoded, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return decoded.version != argon2.Version ||
+		decoded.memory != h.Memory ||
+		decoded.iterations != h.Iterations ||
+		decoded.parallelism != h.Parallelism ||
+		uint32(len(decoded.salt)) != h.SaltLength ||
+		uint32(len(decoded.key)) != h.KeyLength
+}
+
+// decodeArgon2id parses an argon2id hash in the PHC string format
+func decodeArgon2id(hash string) (*argon2idHash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, errors.New("invalid argon2id hash")
+	}
+
+	var decoded argon2idHash
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &decoded.version); err != nil {
+		return nil, errors.New("invalid argon2id hash")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &decoded.memory, &decoded.iterations, &decoded.parallelism); err != nil {
+		return nil, errors.New("invalid argon2id hash")
+	}
+
+	var err error
+	if decoded.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, errors.New("invalid argon2id hash")
+	}
+	if decoded.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(decoded.key) == 0 {
+		return nil, errors.New("invalid argon2id hash")
+	}
+	return &decoded, nil
+}
+
+// BcryptHasher hashes passwords with bcrypt
+type BcryptHasher struct {
+	Cost int
+}
+
+// Algorithm returns the name of the algorithm
+func (h *BcryptHasher) Algorithm() string {
+	return HashAlgorithmBcrypt
+}
+
+// Hash hashes a password with the current cost; the salt is included in the hash
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+// Recognizes reports whether a hash was produced by bcrypt
+func (h *BcryptHasher) Recognizes(hash string) bool {
+	return len(hash) > 4 && (hash[:4] == "$2a$" || hash[:4] == "$2b$" || hash[:4] == "$2y$")
+}
+
+// Verify checks a password against a bcrypt hash
+func (h *BcryptHasher) Verify(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Outdated reports whether a hash was produced with another cost than the current one
+func (h *BcryptHasher) Outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
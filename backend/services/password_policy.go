@@ -0,0 +1,137 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/ginchat/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// commonPasswordPatterns are refused anywhere in a password, even without a breached password corpus
+var commonPasswordPatterns = []string{"password", "123456", "qwerty", "admin", "welcome"}
+
+// PasswordPolicy is the set of rules every new password must satisfy,
+// whether it is chosen at registration, on reset or on change
+type PasswordPolicy struct {
+	// MinLength is the minimum length in characters
+	MinLength int
+	// MaxLength is the maximum length in bytes; bcrypt ignores everything past 72 bytes
+	MaxLength int
+
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+
+	// RejectSimilar refuses passwords containing the username or email address
+	RejectSimilar bool
+
+	// Breached is the breached password corpus, or nil if none is configured
+	Breached *BreachedPasswordList
+
+	logger *logrus.Logger
+}
+
+// LoadPasswordPolicy reads the password policy from the environment
+func LoadPasswordPolicy(logger *logrus.Logger) *PasswordPolicy {
+	policy := &PasswordPolicy{
+		MinLength:     utils.GetEnvInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength:     utils.GetEnvInt("PASSWORD_MAX_LENGTH", 72),
+		RequireUpper:  utils.GetEnvBool("PASSWORD_REQUIRE_UPPER", true),
+		RequireLower:  utils.GetEnvBool("PASSWORD_REQUIRE_LOWER", true),
+		RequireDigit:  utils.GetEnvBool("PASSWORD_REQUIRE_DIGIT", true),
+		RequireSymbol: utils.GetEnvBool("PASSWORD_REQUIRE_SYMBOL", true),
+		RejectSimilar: utils.GetEnvBool("PASSWORD_REJECT_SIMILAR", true),
+		logger:        logger,
+	}
+
+	dir := os.Getenv("PASSWORD_BREACHED_DIR")
+	if dir == "" {
+		dir = "data/breached-passwords"
+	}
+	breached := NewBreachedPasswordList(dir, utils.GetEnvInt("PASSWORD_BREACHED_MIN_COUNT", 1))
+	if breached.Available() {
+		policy.Breached = breached
+	} else {
+		logger.Warnf("Breached password corpus %s not found, passwords are not checked against it", dir)
+	}
+
+	return policy
+}
+
+// Validate checks a new password for the account with the given username and email
+func (p *PasswordPolicy) Validate(password, username, email string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+	if len(password) > p.MaxLength {
+		return fmt.Errorf("password must be at most %d bytes long", p.MaxLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	if p.RequireUpper && !upper {
+		return fmt.Errorf("password must contain at least one uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		return fmt.Errorf("password must contain at least one lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		return fmt.Errorf("password must contain at least one digit")
+	}
+	if p.RequireSymbol && !symbol {
+		return fmt.Errorf("password must contain at least one special character")
+	}
+
+	passwordLower := strings.ToLower(password)
+	for _, common := range commonPasswordPatterns {
+		if strings.Contains(passwordLower, common) {
+			return fmt.Errorf("password contains a common pattern that is easily guessable")
+		}
+	}
+
+	if p.RejectSimilar && p.similar(passwordLower, username, email) {
+		return fmt.Errorf("password must not contain your username or email address")
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			// A corpus that cannot be read must not lock users out of choosing a password
+			p.logger.Errorf("Failed to check breached password corpus: %v", err)
+		} else if breached {
+			return fmt.Errorf("password has appeared in a data breach, please choose another one")
+		}
+	}
+
+	return nil
+}
+
+// similar reports whether a lowercased password contains the username, the
+// email address or its local part
+func (p *PasswordPolicy) similar(passwordLower, username, email string) bool {
+	email = strings.ToLower(email)
+	localPart, _, _ := strings.Cut(email, "@")
+
+	for _, identity := range []string{strings.ToLower(username), email, localPart} {
+		if len(identity) >= 3 && strings.Contains(passwordLower, identity) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+	"gorm.io/gorm"
+)
+
+// PasswordResetService lets users who forgot their password set a new one
+// through a single-use link sent by email
+type PasswordResetService struct {
+	DB       *gorm.DB
+	UserSvc  *UserService
+	TokenSvc *TokenService
+	Tokens   *UserTokenService
+	Mailer   Mailer
+
+	// TTL is how long a reset link stays valid
+	TTL time.Duration
+	// ResendInterval is the minimum time between two reset emails to a user
+	ResendInterval time.Duration
+	// ResendLimit is the maximum number of reset emails sent to a user per day
+	ResendLimit int
+}
+
+// NewPasswordResetService creates a new PasswordResetService
+func NewPasswordResetService(db *gorm.DB, userService *UserService, tokenService *TokenService, userTokenService *UserTokenService, mailer Mailer) *PasswordResetService {
+	return &PasswordResetService{
+		DB:             db,
+		UserSvc:        userService,
+		TokenSvc:       tokenService,
+		Tokens:         userTokenService,
+		Mailer:         mailer,
+		TTL:            utils.GetEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+		ResendInterval: utils.GetEnvDuration("PASSWORD_RESET_RESEND_INTERVAL", time.Minute),
+		ResendLimit:    utils.GetEnvInt("PASSWORD_RESET_RESEND_LIMIT", 5),
+	}
+}
+
+// RequestReset emails a reset link to the account with the given email.
+// It returns the user, or nil without an error if there is no such account,
+// so callers can answer the same way whether or not the email is registered.
+// A user who asked too recently or too often gets no email.
+func (s *PasswordResetService) RequestReset(email string) (*models.User, error) {
+	var user models.User
+	if result := s.DB.Where("email = ?", email).First(&user); result.Error != nil {
+		return nil, nil
+	}
+	if user.Disabled {
+		return nil, nil
+	}
+
+	wait, err := s.Tokens.Throttle(user.UserID, TokenPurposePasswordReset, s.ResendInterval, s.ResendLimit)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		return &user, errors.New("too many reset emails")
+	}
+
+	return &user, s.SendResetLink(&user)
+}
+
+// SendResetLink emails a reset link to a user
+func (s *PasswordResetService) SendResetLink(user *models.User) error {
+	rawToken, err := s.Tokens.Issue(user.UserID, TokenPurposePasswordReset, "", s.TTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(`Hi %s,
+
+Someone asked to reset the password of your GinChat account. To choose a new password, open this link within %s:
+
+%s/auth/reset-password?token=%s
+
+If you did not ask for this, you can ignore this email.
+`, user.Username, s.TTL, AppURL(), rawToken)
+
+	if err := s.Mailer.Send(user.Email, "Reset your GinChat password", body); err != nil {
+		return errors.New("failed to send reset email")
+	}
+	return nil
+}
+
+// UserForToken returns the account a reset token was issued to, without redeeming the token
+func (s *PasswordResetService) UserForToken(rawToken string) (*models.User, error) {
+	token, err := s.Tokens.Find(rawToken, TokenPurposePasswordReset)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.UserSvc.GetUserByID(token.UserID)
+	if err != nil {
+		return nil, errors.New("invalid or expired token")
+	}
+	return user, nil
+}
+
+// ResetPassword sets a new password using a reset token, lifts any login lockout and signs the user out everywhere
+func (s *PasswordResetService) ResetPassword(rawToken, newPassword string) (*models.User, error) {
+	token, err := s.Tokens.Consume(rawToken, TokenPurposePasswordReset)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.UserSvc.GetUserByID(token.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, errors.New("account disabled")
+	}
+
+	hashedPassword, err := s.UserSvc.HashPassword(newPassword)
+	if err != nil {
+		return nil, errors.New("failed to hash password")
+	}
+
+	result := s.DB.Model(user).Updates(map[string]interface{}{
+		"password":                hashedPassword,
+		"password_reset_required": false,
+		"failed_login_attempts":   0,
+		"locked_until":            nil,
+	})
+	if result.Error != nil {
+		return nil, errors.New("failed to update password")
+	}
+
+	if err := s.TokenSvc.RevokeUser(user.UserID, RevokeReasonPasswordChange); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,343 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Presence statuses stored in models.User.Status
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+// PresenceEvent describes a change in a user's presence status
+type PresenceEvent struct {
+	UserID     uint      `json:"user_id"`
+	Status     string    `json:"status"`
+	LastSeen   time.Time `json:"last_seen"`
+	Recipients []uint    `json:"-"`
+}
+
+// PresenceService tracks which users are online, away or offline.
+// Status and heartbeat are persisted on models.User and open connection
+// counts in presence_connections, per instance, so a user is only marked
+// offline once no instance holds a connection for them. Activity is tracked
+// per process.
+type PresenceService struct {
+	DB      *gorm.DB
+	ChatSvc *ChatroomService
+
+	// InstanceID identifies this process's rows in presence_connections
+	InstanceID string
+
+	// AwayAfter is the inactivity period after which a connected user becomes away
+	AwayAfter time.Duration
+	// OfflineAfter is the heartbeat age after which a user is considered offline
+	OfflineAfter time.Duration
+	// SweepInterval is how often stale and inactive users are checked
+	SweepInterval time.Duration
+
+	connections map[uint]int
+	lastActive  map[uint]time.Time
+	away        map[uint]bool
+	listeners   []func(PresenceEvent)
+	mux         sync.Mutex
+	connMux     sync.Mutex // Serializes connection count updates so they reach the database in order
+	stop        chan struct{}
+}
+
+// NewPresenceService creates a new PresenceService
+func NewPresenceService(db *gorm.DB, chatroomService *ChatroomService) *PresenceService {
+	return &PresenceService{
+		DB:            db,
+		ChatSvc:       chatroomService,
+		InstanceID:    newInstanceID(),
+		AwayAfter:     utils.GetEnvDuration("PRESENCE_AWAY_AFTER", 5*time.Minute),
+		OfflineAfter:  utils.GetEnvDuration("PRESENCE_OFFLINE_AFTER", 2*time.Minute),
+		SweepInterval: utils.GetEnvDuration("PRESENCE_SWEEP_INTERVAL", 30*time.Second),
+		connections:   make(map[uint]int),
+		lastActive:    make(map[uint]time.Time),
+		away:          make(map[uint]bool),
+		stop:          make(chan struct{}),
+	}
+}
+
+// OnChange registers a listener that is called whenever a user's status changes
+func (s *PresenceService) OnChange(listener func(PresenceEvent)) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+// Connect records a new connection for a user and marks them online
+func (s *PresenceService) Connect(userID uint) error {
+	now := time.Now()
+
+	s.connMux.Lock()
+	s.mux.Lock()
+	s.connections[userID]++
+	count := s.connections[userID]
+	s.lastActive[userID] = now
+	delete(s.away, userID)
+	s.mux.Unlock()
+	err := s.saveConnections(userID, count, now)
+	s.connMux.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return s.setStatus(userID, StatusOnline, now)
+}
+
+// Disconnect records a closed connection and marks the user offline once
+// their last connection on every instance is gone
+func (s *PresenceService) Disconnect(userID uint) error {
+	now := time.Now()
+
+	s.connMux.Lock()
+	s.mux.Lock()
+	s.connections[userID]--
+	remaining := s.connections[userID]
+	if remaining <= 0 {
+		delete(s.connections, userID)
+		delete(s.lastActive, userID)
+		delete(s.away, userID)
+	}
+	s.mux.Unlock()
+	err := s.saveConnections(userID, remaining, now)
+	s.connMux.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if remaining > 0 {
+		return nil
+	}
+
+	// The user may still be connected to another instance
+	var elsewhere int64
+	result := s.DB.Model(&models.PresenceConnection{}).
+		Where("user_id = ? AND updated_at >= ?", userID, now.Add(-s.OfflineAfter)).
+		Count(&elsewhere)
+	if result.Error != nil {
+		return errors.New("failed to check user connections")
+	}
+	if elsewhere > 0 {
+		return nil
+	}
+
+	return s.setStatus(userID, StatusOffline, now)
+}
+
+// LocalConnections returns how many connections a user has to this instance
+func (s *PresenceService) LocalConnections(userID uint) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.connections[userID]
+}
+
+// saveConnections stores this instance's connection count for a user
+func (s *PresenceService) saveConnections(userID uint, count int, now time.Time) error {
+	var result *gorm.DB
+	if count > 0 {
+		result = s.DB.Clauses(clause.OnConflict{
+			DoUpdates: clause.AssignmentColumns([]string{"connections", "updated_at"}),
+		}).Create(&models.PresenceConnection{
+			InstanceID:  s.InstanceID,
+			UserID:      userID,
+			Connections: count,
+			UpdatedAt:   now,
+		})
+	} else {
+		result = s.DB.Where("instance_id = ? AND user_id = ?", s.InstanceID, userID).
+			Delete(&models.PresenceConnection{})
+	}
+	if result.Error != nil {
+		return errors.New("failed to update user connections")
+	}
+	return nil
+}
+
+// Heartbeat updates a user's heartbeat timestamp
+func (s *PresenceService) Heartbeat(userID uint) error {
+	s.mux.Lock()
+	away := s.away[userID]
+	s.mux.Unlock()
+
+	status := StatusOnline
+	if away {
+		status = StatusAway
+	}
+	return s.setStatus(userID, status, time.Now())
+}
+
+// Touch records user activity, bringing an away user back online
+func (s *PresenceService) Touch(userID uint) error {
+	s.mux.Lock()
+	s.lastActive[userID] = time.Now()
+	wasAway := s.away[userID]
+	delete(s.away, userID)
+	s.mux.Unlock()
+
+	if !wasAway {
+		return nil
+	}
+	return s.setStatus(userID, StatusOnline, time.Now())
+}
+
+// Start runs the sweeper that marks inactive users away and stale users offline
+func (s *PresenceService) Start() {
+	go func() {
+		ticker := time.NewTicker(s.SweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.sweep()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the sweeper and drops this instance's connection counts
+func (s *PresenceService) Stop() {
+	close(s.stop)
+	s.DB.Where("instance_id = ?", s.InstanceID).Delete(&models.PresenceConnection{})
+}
+
+// sweep marks connected users away after inactivity and users with a stale heartbeat offline
+func (s *PresenceService) sweep() {
+	now := time.Now()
+
+	var idle []uint
+	s.mux.Lock()
+	for userID, lastActive := range s.lastActive {
+		if !s.away[userID] && now.Sub(lastActive) >= s.AwayAfter {
+			s.away[userID] = true
+			idle = append(idle, userID)
+		}
+	}
+	s.mux.Unlock()
+
+	for _, userID := range idle {
+		s.setStatusOnly(userID, StatusAway)
+	}
+
+	// Connections on this instance are kept alive by ping/pong, so they count as a heartbeat
+	s.mux.Lock()
+	connected := make([]uint, 0, len(s.connections))
+	for userID := range s.connections {
+		connected = append(connected, userID)
+	}
+	s.mux.Unlock()
+
+	if len(connected) > 0 {
+		s.DB.Model(&models.User{}).Where("user_id IN ?", connected).Update("heartbeat", now)
+	}
+
+	// Keep this instance's connection counts alive and drop those of instances that stopped
+	cutoff := now.Add(-s.OfflineAfter)
+	s.DB.Model(&models.PresenceConnection{}).Where("instance_id = ?", s.InstanceID).Update("updated_at", now)
+	s.DB.Where("updated_at < ?", cutoff).Delete(&models.PresenceConnection{})
+
+	// Users whose heartbeat stopped without a clean disconnect, possibly on another instance
+	var stale []models.User
+	s.DB.Where("status IN ?", []string{StatusOnline, StatusAway}).
+		Where("heartbeat IS NULL OR heartbeat < ?", cutoff).
+		Find(&stale)
+
+	for _, user := range stale {
+		s.setStatusOnly(user.UserID, StatusOffline)
+	}
+}
+
+// setStatus updates a user's status and heartbeat, notifying listeners if the status changed
+func (s *PresenceService) setStatus(userID uint, status string, heartbeat time.Time) error {
+	var user models.User
+	if result := s.DB.First(&user, userID); result.Error != nil {
+		return errors.New("user not found")
+	}
+
+	result := s.DB.Model(&user).Updates(map[string]interface{}{
+		"status":    status,
+		"heartbeat": heartbeat,
+	})
+	if result.Error != nil {
+		return errors.New("failed to update user presence")
+	}
+
+	if user.Status != status {
+		s.notify(userID, status, heartbeat)
+	}
+	return nil
+}
+
+// setStatusOnly updates a user's status without touching the heartbeat
+func (s *PresenceService) setStatusOnly(userID uint, status string) {
+	var user models.User
+	if result := s.DB.First(&user, userID); result.Error != nil || user.Status == status {
+		return
+	}
+
+	if result := s.DB.Model(&user).Update("status", status); result.Error != nil {
+		return
+	}
+
+	lastSeen := user.UpdatedAt
+	if user.Heartbeat != nil {
+		lastSeen = *user.Heartbeat
+	}
+	s.notify(userID, status, lastSeen)
+}
+
+// notify sends a presence change to the users who share a chatroom with the user
+func (s *PresenceService) notify(userID uint, status string, lastSeen time.Time) {
+	recipients, err := s.ChatSvc.GetRoommateIDs(userID)
+	if err != nil {
+		return
+	}
+
+	event := PresenceEvent{
+		UserID:     userID,
+		Status:     status,
+		LastSeen:   lastSeen,
+		Recipients: recipients,
+	}
+
+	s.mux.Lock()
+	listeners := append([]func(PresenceEvent){}, s.listeners...)
+	s.mux.Unlock()
+
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
+
+// newInstanceID returns an ID unique to this process
+func newInstanceID() string {
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "instance"
+	}
+	if len(hostname) > 48 {
+		hostname = hostname[:48]
+	}
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
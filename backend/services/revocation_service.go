@@ -0,0 +1,170 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reasons recorded when access tokens are revoked
+const (
+	RevokeReasonLogout         = "logout"
+	RevokeReasonPasswordChange = "password_change"
+	RevokeReasonSessionRevoked = "session_revoked"
+	RevokeReasonTokenReuse     = "token_reuse"
+	RevokeReasonForcedLogout   = "forced_logout"
+	RevokeReasonDisabled       = "account_disabled"
+	RevokeReasonPasswordReset  = "password_reset"
+)
+
+// RevocationEvent describes access tokens of a user that were revoked
+type RevocationEvent struct {
+	UserID   uint     `json:"user_id"`
+	TokenIDs []string `json:"token_ids"`
+	Reason   string   `json:"reason"`
+}
+
+// RevocationService tracks revoked access tokens by jti. Revocations are
+// persisted in MySQL so every instance sees them; confirmed revocations are
+// cached in memory so repeated requests with a revoked token skip the database.
+type RevocationService struct {
+	DB *gorm.DB
+
+	// CleanupInterval is how often revocations of expired tokens are deleted
+	CleanupInterval time.Duration
+
+	revoked   map[string]time.Time
+	listeners []func(RevocationEvent)
+	mux       sync.RWMutex
+	stop      chan struct{}
+}
+
+// NewRevocationService creates a new RevocationService
+func NewRevocationService(db *gorm.DB) *RevocationService {
+	return &RevocationService{
+		DB:              db,
+		CleanupInterval: utils.GetEnvDuration("REVOCATION_CLEANUP_INTERVAL", 10*time.Minute),
+		revoked:         make(map[string]time.Time),
+		stop:            make(chan struct{}),
+	}
+}
+
+// OnRevoke registers a listener that is called whenever access tokens are revoked
+func (s *RevocationService) OnRevoke(listener func(RevocationEvent)) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+// Revoke revokes access tokens of a user until they expire
+func (s *RevocationService) Revoke(userID uint, tokenIDs []string, expiresAt time.Time, reason string) error {
+	if len(tokenIDs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	rows := make([]models.RevokedToken, 0, len(tokenIDs))
+	for _, tokenID := range tokenIDs {
+		rows = append(rows, models.RevokedToken{
+			TokenID:   tokenID,
+			UserID:    userID,
+			Reason:    reason,
+			ExpiresAt: expiresAt,
+			CreatedAt: now,
+		})
+	}
+
+	result := s.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows)
+	if result.Error != nil {
+		return errors.New("failed to revoke tokens")
+	}
+
+	s.mux.Lock()
+	for _, tokenID := range tokenIDs {
+		s.revoked[tokenID] = expiresAt
+	}
+	listeners := append([]func(RevocationEvent){}, s.listeners...)
+	s.mux.Unlock()
+
+	event := RevocationEvent{UserID: userID, TokenIDs: tokenIDs, Reason: reason}
+	for _, listener := range listeners {
+		listener(event)
+	}
+	return nil
+}
+
+// IsRevoked reports whether an access token has been revoked.
+// Cache misses fall through to the database, so revocations made by other
+// instances take effect immediately.
+func (s *RevocationService) IsRevoked(tokenID string) (bool, error) {
+	s.mux.RLock()
+	_, ok := s.revoked[tokenID]
+	s.mux.RUnlock()
+	if ok {
+		return true, nil
+	}
+
+	var token models.RevokedToken
+	result := s.DB.Where("token_id = ?", tokenID).Limit(1).Find(&token)
+	if result.Error != nil {
+		return false, errors.New("failed to check token revocation")
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	s.mux.Lock()
+	s.revoked[tokenID] = token.ExpiresAt
+	s.mux.Unlock()
+	return true, nil
+}
+
+// Start loads the current revocations into the cache and runs the cleanup of expired ones
+func (s *RevocationService) Start() {
+	var tokens []models.RevokedToken
+	s.DB.Where("expires_at > ?", time.Now()).Find(&tokens)
+
+	s.mux.Lock()
+	for _, token := range tokens {
+		s.revoked[token.TokenID] = token.ExpiresAt
+	}
+	s.mux.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(s.CleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.cleanup()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the cleanup
+func (s *RevocationService) Stop() {
+	close(s.stop)
+}
+
+// cleanup deletes revocations of tokens that have expired on their own
+func (s *RevocationService) cleanup() {
+	now := time.Now()
+	s.DB.Where("expires_at <= ?", now).Delete(&models.RevokedToken{})
+
+	s.mux.Lock()
+	for tokenID, expiresAt := range s.revoked {
+		if !expiresAt.After(now) {
+			delete(s.revoked, tokenID)
+		}
+	}
+	s.mux.Unlock()
+}
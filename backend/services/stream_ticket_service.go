@@ -0,0 +1,63 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ginchat/utils"
+)
+
+// StreamTicketService issues single-use tickets that authenticate an event
+// stream through its URL, for clients such as the browser EventSource API
+// that cannot send an Authorization header. A ticket stands for the access
+// token it was requested with and dies with it.
+type StreamTicketService struct {
+	Tokens      *UserTokenService
+	Revocations *RevocationService
+
+	// TTL is how long a ticket can be used to open a stream
+	TTL time.Duration
+}
+
+// NewStreamTicketService creates a new StreamTicketService
+func NewStreamTicketService(userTokenService *UserTokenService, revocationService *RevocationService) *StreamTicketService {
+	return &StreamTicketService{
+		Tokens:      userTokenService,
+		Revocations: revocationService,
+		TTL:         utils.GetEnvDuration("STREAM_TICKET_TTL", 30*time.Second),
+	}
+}
+
+// Issue creates a ticket for a user's access token. Tickets of the user's
+// other tabs and devices stay valid.
+func (s *StreamTicketService) Issue(userID uint, tokenID string) (string, time.Time, error) {
+	// Every (re)connect spends a ticket, so drop the spent ones while issuing a new one
+	s.Tokens.DeleteSpent(TokenPurposeStreamTicket)
+
+	expiresAt := time.Now().Add(s.TTL)
+	ticket, err := s.Tokens.IssueAdditional(userID, TokenPurposeStreamTicket, tokenID, s.TTL)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return ticket, expiresAt, nil
+}
+
+// Redeem consumes a ticket and returns the user and the ID of the access
+// token it was issued for
+func (s *StreamTicketService) Redeem(ticket string) (uint, string, error) {
+	token, err := s.Tokens.Consume(ticket, TokenPurposeStreamTicket)
+	if err != nil {
+		return 0, "", errors.New("invalid or expired ticket")
+	}
+
+	// The access token may have been revoked since the ticket was issued
+	revoked, err := s.Revocations.IsRevoked(token.Data)
+	if err != nil {
+		return 0, "", errors.New("failed to verify ticket")
+	}
+	if revoked {
+		return 0, "", errors.New("invalid or expired ticket")
+	}
+
+	return token.UserID, token.Data, nil
+}
@@ -0,0 +1,310 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+	"gorm.io/gorm"
+)
+
+// TokenPair is a short-lived access token and the refresh token that renews it
+type TokenPair struct {
+	AccessToken      string    `json:"token"`
+	AccessExpiresAt  time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	AccessTokenID    string    `json:"-"`
+	FamilyID         string    `json:"-"`
+}
+
+// TokenService issues access tokens and rotates refresh tokens
+type TokenService struct {
+	DB          *gorm.DB
+	Revocations *RevocationService
+	// RefreshTTL is how long a refresh token stays valid
+	RefreshTTL time.Duration
+}
+
+// NewTokenService creates a new TokenService
+func NewTokenService(db *gorm.DB, revocationService *RevocationService) *TokenService {
+	return &TokenService{
+		DB:          db,
+		Revocations: revocationService,
+		RefreshTTL:  utils.GetEnvDuration("REFRESH_TOKEN_EXPIRATION", 30*24*time.Hour),
+	}
+}
+
+// IssueTokens starts a new session for a login and issues its access and refresh tokens
+func (s *TokenService) IssueTokens(user *models.User, ipAddress, userAgent string) (*TokenPair, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, errors.New("failed to generate token family")
+	}
+
+	now := time.Now()
+	session := models.Session{
+		ID:         familyID,
+		UserID:     user.UserID,
+		Device:     utils.DescribeDevice(userAgent),
+		UserAgent:  truncate(userAgent, 255),
+		IPAddress:  ipAddress,
+		LastSeenAt: now,
+		CreatedAt:  now,
+	}
+	if result := s.DB.Create(&session); result.Error != nil {
+		return nil, errors.New("failed to create session")
+	}
+
+	return s.issue(user, familyID)
+}
+
+// Refresh exchanges a refresh token for a new token pair in the same family.
+// Presenting a token that was already used revokes the whole family, since
+// either the legitimate client or an attacker holds a stolen copy.
+func (s *TokenService) Refresh(rawToken, ipAddress, userAgent string) (*TokenPair, *models.User, error) {
+	var token models.RefreshToken
+	if result := s.DB.Where("token_hash = ?", hashToken(rawToken)).First(&token); result.Error != nil {
+		return nil, nil, errors.New("invalid refresh token")
+	}
+
+	if token.RevokedAt != nil {
+		return nil, nil, errors.New("refresh token revoked")
+	}
+	if token.UsedAt != nil {
+		s.RevokeFamily(token.FamilyID, RevokeReasonTokenReuse)
+		return nil, nil, errors.New("refresh token reuse detected")
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, nil, errors.New("refresh token expired")
+	}
+
+	// Mark the token used; losing this race to a concurrent request is also reuse
+	now := time.Now()
+	result := s.DB.Model(&models.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", token.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, nil, errors.New("failed to rotate refresh token")
+	}
+	if result.RowsAffected == 0 {
+		s.RevokeFamily(token.FamilyID, RevokeReasonTokenReuse)
+		return nil, nil, errors.New("refresh token reuse detected")
+	}
+
+	var user models.User
+	if result := s.DB.First(&user, token.UserID); result.Error != nil {
+		return nil, nil, errors.New("user not found")
+	}
+	if user.Disabled {
+		s.RevokeFamily(token.FamilyID, RevokeReasonDisabled)
+		return nil, nil, errors.New("account disabled")
+	}
+	if user.PasswordResetRequired {
+		s.RevokeFamily(token.FamilyID, RevokeReasonPasswordReset)
+		return nil, nil, errors.New("password reset required")
+	}
+
+	pair, err := s.issue(&user, token.FamilyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Record where the session was last used
+	s.DB.Model(&models.Session{}).Where("id = ?", token.FamilyID).Updates(map[string]interface{}{
+		"last_seen_at": now,
+		"ip_address":   ipAddress,
+		"user_agent":   truncate(userAgent, 255),
+		"device":       utils.DescribeDevice(userAgent),
+	})
+
+	return pair, &user, nil
+}
+
+// RevokeFamily revokes every refresh token of a family and the access tokens issued with them
+func (s *TokenService) RevokeFamily(familyID, reason string) error {
+	var tokens []models.RefreshToken
+	if result := s.DB.Where("family_id = ?", familyID).Find(&tokens); result.Error != nil {
+		return errors.New("failed to revoke refresh tokens")
+	}
+	return s.revoke(tokens, reason)
+}
+
+// RevokeUser revokes every refresh token and access token of a user, e.g. after a password change
+func (s *TokenService) RevokeUser(userID uint, reason string) error {
+	var tokens []models.RefreshToken
+	if result := s.DB.Where("user_id = ? AND expires_at > ?", userID, time.Now()).Find(&tokens); result.Error != nil {
+		return errors.New("failed to revoke refresh tokens")
+	}
+	return s.revoke(tokens, reason)
+}
+
+// RevokeOtherSessions revokes the tokens of every session of a user except
+// one, e.g. to sign out other devices after a password change
+func (s *TokenService) RevokeOtherSessions(userID uint, keepSessionID, reason string) error {
+	var tokens []models.RefreshToken
+	result := s.DB.Where("user_id = ? AND family_id <> ? AND expires_at > ?", userID, keepSessionID, time.Now()).Find(&tokens)
+	if result.Error != nil {
+		return errors.New("failed to revoke refresh tokens")
+	}
+	return s.revoke(tokens, reason)
+}
+
+// RevokeRefreshToken revokes the family of a refresh token presented by its owner
+func (s *TokenService) RevokeRefreshToken(userID uint, rawToken, reason string) error {
+	var token models.RefreshToken
+	if result := s.DB.Where("token_hash = ? AND user_id = ?", hashToken(rawToken), userID).First(&token); result.Error != nil {
+		return errors.New("invalid refresh token")
+	}
+	return s.RevokeFamily(token.FamilyID, reason)
+}
+
+// ListSessions returns a user's active sessions, most recently used first
+func (s *TokenService) ListSessions(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	result := s.DB.Where("user_id = ? AND revoked_at IS NULL AND last_seen_at > ?", userID, time.Now().Add(-s.RefreshTTL)).
+		Order("last_seen_at DESC").
+		Find(&sessions)
+	if result.Error != nil {
+		return nil, errors.New("failed to fetch sessions")
+	}
+	return sessions, nil
+}
+
+// RevokeSession signs out one of a user's sessions, closing its live connections
+func (s *TokenService) RevokeSession(userID uint, sessionID string) error {
+	var session models.Session
+	result := s.DB.Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).First(&session)
+	if result.Error != nil {
+		return errors.New("session not found")
+	}
+	return s.RevokeFamily(session.ID, RevokeReasonSessionRevoked)
+}
+
+// SessionIDForToken returns the session an access token was issued for
+func (s *TokenService) SessionIDForToken(tokenID string) (string, error) {
+	var token models.RefreshToken
+	if result := s.DB.Where("access_token_id = ?", tokenID).First(&token); result.Error != nil {
+		return "", errors.New("session not found")
+	}
+	return token.FamilyID, nil
+}
+
+// RevokeAccessToken revokes a single access token until it expires
+func (s *TokenService) RevokeAccessToken(userID uint, tokenID string, expiresAt time.Time, reason string) error {
+	return s.Revocations.Revoke(userID, []string{tokenID}, expiresAt, reason)
+}
+
+// revoke marks refresh tokens revoked and revokes the access tokens issued
+// with them that may not have expired yet
+func (s *TokenService) revoke(tokens []models.RefreshToken, reason string) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	accessTTL, err := utils.JWTExpiration()
+	if err != nil {
+		return errors.New("invalid access token expiration")
+	}
+
+	now := time.Now()
+	ids := make([]uint, 0, len(tokens))
+	familyIDs := make(map[string]bool)
+	accessTokenIDs := make(map[uint][]string)
+	for _, token := range tokens {
+		ids = append(ids, token.ID)
+		familyIDs[token.FamilyID] = true
+		if token.AccessTokenID != "" && token.CreatedAt.Add(accessTTL).After(now) {
+			accessTokenIDs[token.UserID] = append(accessTokenIDs[token.UserID], token.AccessTokenID)
+		}
+	}
+
+	result := s.DB.Model(&models.RefreshToken{}).
+		Where("id IN ? AND revoked_at IS NULL", ids).
+		Update("revoked_at", now)
+	if result.Error != nil {
+		return errors.New("failed to revoke refresh tokens")
+	}
+
+	sessionIDs := make([]string, 0, len(familyIDs))
+	for familyID := range familyIDs {
+		sessionIDs = append(sessionIDs, familyID)
+	}
+	result = s.DB.Model(&models.Session{}).
+		Where("id IN ? AND revoked_at IS NULL", sessionIDs).
+		Update("revoked_at", now)
+	if result.Error != nil {
+		return errors.New("failed to revoke sessions")
+	}
+
+	for userID, tokenIDs := range accessTokenIDs {
+		if err := s.Revocations.Revoke(userID, tokenIDs, now.Add(accessTTL), reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// issue creates an access token and a refresh token in a family
+func (s *TokenService) issue(user *models.User, familyID string) (*TokenPair, error) {
+	accessToken, claims, err := utils.IssueJWT(user.UserID, user.Username, user.Email, user.Role)
+	if err != nil {
+		return nil, errors.New("failed to generate token")
+	}
+
+	rawToken, err := randomToken(32)
+	if err != nil {
+		return nil, errors.New("failed to generate refresh token")
+	}
+
+	now := time.Now()
+	refreshToken := models.RefreshToken{
+		UserID:        user.UserID,
+		FamilyID:      familyID,
+		TokenHash:     hashToken(rawToken),
+		AccessTokenID: claims.Id,
+		ExpiresAt:     now.Add(s.RefreshTTL),
+		CreatedAt:     now,
+	}
+	if result := s.DB.Create(&refreshToken); result.Error != nil {
+		return nil, errors.New("failed to store refresh token")
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  time.Unix(claims.ExpiresAt, 0),
+		RefreshToken:     rawToken,
+		RefreshExpiresAt: refreshToken.ExpiresAt,
+		AccessTokenID:    claims.Id,
+		FamilyID:         familyID,
+	}, nil
+}
+
+// randomToken returns a URL-safe random string of n bytes of entropy
+func randomToken(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// truncate shortens a string to at most n characters without splitting a character
+func truncate(value string, n int) string {
+	if utf8.RuneCountInString(value) <= n {
+		return value
+	}
+	return string([]rune(value)[:n])
+}
+
+// hashToken returns the SHA-256 hex digest under which an opaque token is stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
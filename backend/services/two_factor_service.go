@@ -0,0 +1,316 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+	"gorm.io/gorm"
+)
+
+// recoveryCodeEncoding encodes recovery codes in lowercase base32, which is easy to type
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// TwoFactorService manages TOTP two-factor authentication and the login
+// challenges that are completed with a TOTP or recovery code
+type TwoFactorService struct {
+	DB     *gorm.DB
+	Tokens *UserTokenService
+
+	// Issuer is the account issuer shown by authenticator apps
+	Issuer string
+	// ChallengeTTL is how long a password-verified login has to complete the second step
+	ChallengeTTL time.Duration
+	// ChallengeAttempts is how many wrong codes a login challenge accepts before it is invalidated
+	ChallengeAttempts int
+	// RecoveryCodes is the number of recovery codes generated at a time
+	RecoveryCodes int
+	// Skew is the number of time steps before and after the current one whose codes are accepted
+	Skew int
+}
+
+// NewTwoFactorService creates a new TwoFactorService
+func NewTwoFactorService(db *gorm.DB, userTokenService *UserTokenService) *TwoFactorService {
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "GinChat"
+	}
+
+	return &TwoFactorService{
+		DB:                db,
+		Tokens:            userTokenService,
+		Issuer:            issuer,
+		ChallengeTTL:      utils.GetEnvDuration("TWO_FACTOR_CHALLENGE_TTL", 5*time.Minute),
+		ChallengeAttempts: utils.GetEnvInt("TWO_FACTOR_CHALLENGE_ATTEMPTS", 5),
+		RecoveryCodes:     utils.GetEnvInt("TWO_FACTOR_RECOVERY_CODES", 10),
+		Skew:              1,
+	}
+}
+
+// Setup generates a new TOTP secret for a user. Two-factor authentication is
+// enabled once the secret is confirmed with a code.
+func (s *TwoFactorService) Setup(user *models.User) (string, string, error) {
+	if user.TwoFactorEnabled {
+		return "", "", errors.New("two-factor authentication already enabled")
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", errors.New("failed to generate secret")
+	}
+
+	if result := s.DB.Model(user).Update("totp_secret", secret); result.Error != nil {
+		return "", "", errors.New("failed to update user")
+	}
+
+	return secret, utils.TOTPURI(s.Issuer, user.Email, secret), nil
+}
+
+// Confirm enables two-factor authentication once the user proves their
+// authenticator works, and returns a fresh set of recovery codes
+func (s *TwoFactorService) Confirm(user *models.User, code string) ([]string, error) {
+	if user.TwoFactorEnabled {
+		return nil, errors.New("two-factor authentication already enabled")
+	}
+	if user.TOTPSecret == "" {
+		return nil, errors.New("two-factor authentication not set up")
+	}
+
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now(), s.Skew)
+	if !ok {
+		return nil, errors.New("invalid code")
+	}
+
+	var codes []string
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(user).Updates(map[string]interface{}{
+			"two_factor_enabled": true,
+			"totp_last_step":     step,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		var err error
+		codes, err = s.replaceRecoveryCodes(tx, user.UserID)
+		return err
+	})
+	if err != nil {
+		return nil, errors.New("failed to enable two-factor authentication")
+	}
+
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off after checking a TOTP or recovery code
+func (s *TwoFactorService) Disable(user *models.User, code string) error {
+	if !user.TwoFactorEnabled {
+		return errors.New("two-factor authentication not enabled")
+	}
+	if _, err := s.verifyCode(user, code); err != nil {
+		return err
+	}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(user).Updates(map[string]interface{}{
+			"two_factor_enabled": false,
+			"totp_secret":        "",
+			"totp_last_step":     0,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Where("user_id = ?", user.UserID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		return errors.New("failed to disable two-factor authentication")
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes after checking a TOTP code
+func (s *TwoFactorService) RegenerateRecoveryCodes(user *models.User, code string) ([]string, error) {
+	if !user.TwoFactorEnabled {
+		return nil, errors.New("two-factor authentication not enabled")
+	}
+	if err := s.verifyTOTP(user, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = s.replaceRecoveryCodes(tx, user.UserID)
+		return err
+	})
+	if err != nil {
+		return nil, errors.New("failed to generate recovery codes")
+	}
+	return codes, nil
+}
+
+// RemainingRecoveryCodes returns how many unused recovery codes a user has
+func (s *TwoFactorService) RemainingRecoveryCodes(userID uint) (int64, error) {
+	var count int64
+	result := s.DB.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count)
+	if result.Error != nil {
+		return 0, errors.New("failed to count recovery codes")
+	}
+	return count, nil
+}
+
+// Challenge starts the second login step for a user whose password was verified
+func (s *TwoFactorService) Challenge(user *models.User) (string, time.Time, error) {
+	expiresAt := time.Now().Add(s.ChallengeTTL)
+	token, err := s.Tokens.Issue(user.UserID, TokenPurposeTwoFactor, "", s.ChallengeTTL)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// findChallenge returns a login challenge that can still be completed,
+// rejecting challenges whose attempts are used up
+func (s *TwoFactorService) findChallenge(challenge string) (*models.UserToken, error) {
+	token, err := s.Tokens.Find(challenge, TokenPurposeTwoFactor)
+	if err != nil || token.Attempts >= s.ChallengeAttempts {
+		return nil, errors.New("invalid or expired challenge")
+	}
+	return token, nil
+}
+
+// ChallengeUser returns the user a login challenge was issued to, without checking a code
+func (s *TwoFactorService) ChallengeUser(challenge string) (*models.User, error) {
+	token, err := s.findChallenge(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if result := s.DB.First(&user, token.UserID); result.Error != nil {
+		return nil, errors.New("invalid or expired challenge")
+	}
+	return &user, nil
+}
+
+// CompleteChallenge checks the code for a login challenge and returns the
+// user it was issued to, and whether a recovery code was used
+func (s *TwoFactorService) CompleteChallenge(challenge, code string) (*models.User, bool, error) {
+	token, err := s.findChallenge(challenge)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var user models.User
+	if result := s.DB.First(&user, token.UserID); result.Error != nil {
+		return nil, false, errors.New("invalid or expired challenge")
+	}
+
+	// Count the attempt before checking the code, so parallel guesses cannot exceed the limit
+	if err := s.Tokens.Attempt(token, s.ChallengeAttempts); err != nil {
+		if err.Error() == "invalid or expired token" {
+			return nil, false, errors.New("invalid or expired challenge")
+		}
+		return nil, false, err
+	}
+
+	recovery, err := s.verifyCode(&user, code)
+	if err != nil {
+		if err.Error() == "invalid code" {
+			s.Tokens.RecordFailure(token, s.ChallengeAttempts)
+		}
+		return nil, false, err
+	}
+
+	// A challenge completes a single login
+	if _, err := s.Tokens.Consume(challenge, TokenPurposeTwoFactor); err != nil {
+		return nil, false, errors.New("invalid or expired challenge")
+	}
+
+	return &user, recovery, nil
+}
+
+// verifyCode checks a TOTP code, falling back to a recovery code, and reports
+// whether a recovery code was used
+func (s *TwoFactorService) verifyCode(user *models.User, code string) (bool, error) {
+	if err := s.verifyTOTP(user, code); err == nil {
+		return false, nil
+	} else if err.Error() != "invalid code" {
+		return false, err
+	}
+
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	if normalized == "" {
+		return false, errors.New("invalid code")
+	}
+
+	// Mark the code used; a concurrent use leaves no row to update
+	result := s.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.UserID, hashToken(normalized)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, errors.New("failed to verify code")
+	}
+	if result.RowsAffected == 0 {
+		return false, errors.New("invalid code")
+	}
+	return true, nil
+}
+
+// verifyTOTP checks a TOTP code, refusing a code from a time step that was already used
+func (s *TwoFactorService) verifyTOTP(user *models.User, code string) error {
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now(), s.Skew)
+	if !ok || step <= user.TOTPLastStep {
+		return errors.New("invalid code")
+	}
+
+	// Record the step; a concurrent use of the same code leaves no row to update
+	result := s.DB.Model(&models.User{}).
+		Where("user_id = ? AND totp_last_step < ?", user.UserID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return errors.New("failed to verify code")
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("invalid code")
+	}
+
+	user.TOTPLastStep = step
+	return nil
+}
+
+// replaceRecoveryCodes deletes a user's recovery codes and stores a new set,
+// returning the codes in the xxxxx-xxxxx form shown to the user
+func (s *TwoFactorService) replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	codes := make([]string, 0, s.RecoveryCodes)
+	records := make([]models.RecoveryCode, 0, s.RecoveryCodes)
+	for i := 0; i < s.RecoveryCodes; i++ {
+		bytes := make([]byte, 10)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, err
+		}
+		code := recoveryCodeEncoding.EncodeToString(bytes)[:10]
+
+		codes = append(codes, code[:5]+"-"+code[5:])
+		records = append(records, models.RecoveryCode{
+			UserID:    userID,
+			CodeHash:  hashToken(code),
+			CreatedAt: now,
+		})
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
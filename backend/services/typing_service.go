@@ -0,0 +1,134 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ginchat/utils"
+)
+
+// TypingEvent describes a user starting or stopping typing in a chatroom
+type TypingEvent struct {
+	ChatroomID string `json:"chatroom_id"`
+	UserID     uint   `json:"user_id"`
+	Username   string `json:"username"`
+	Typing     bool   `json:"typing"`
+}
+
+// typingKey identifies a user typing in a chatroom
+type typingKey struct {
+	chatroomID string
+	userID     uint
+}
+
+// typingState is a user's typing indicator and the timer that expires it
+type typingState struct {
+	username string
+	timer    *time.Timer
+}
+
+// TypingService tracks typing indicators and expires them on the server,
+// so a client that disappears mid-typing never leaves a stuck indicator
+type TypingService struct {
+	// Timeout is how long a typing indicator lasts without being refreshed
+	Timeout time.Duration
+
+	typing    map[typingKey]*typingState
+	listeners []func(TypingEvent)
+	mux       sync.Mutex
+}
+
+// NewTypingService creates a new TypingService
+func NewTypingService() *TypingService {
+	return &TypingService{
+		Timeout: utils.GetEnvDuration("TYPING_TIMEOUT", 6*time.Second),
+		typing:  make(map[typingKey]*typingState),
+	}
+}
+
+// OnChange registers a listener that is called whenever a user starts or stops typing
+func (s *TypingService) OnChange(listener func(TypingEvent)) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+// StartTyping marks a user as typing in a chatroom, or refreshes the indicator if they already are
+func (s *TypingService) StartTyping(chatroomID string, userID uint, username string) {
+	key := typingKey{chatroomID: chatroomID, userID: userID}
+
+	s.mux.Lock()
+	if state, ok := s.typing[key]; ok {
+		state.timer.Reset(s.Timeout)
+		s.mux.Unlock()
+		return
+	}
+
+	state := &typingState{username: username}
+	state.timer = time.AfterFunc(s.Timeout, func() {
+		s.expire(key, state)
+	})
+	s.typing[key] = state
+	s.mux.Unlock()
+
+	s.notify(TypingEvent{ChatroomID: chatroomID, UserID: userID, Username: username, Typing: true})
+}
+
+// StopTyping clears a user's typing indicator in a chatroom
+func (s *TypingService) StopTyping(chatroomID string, userID uint) {
+	key := typingKey{chatroomID: chatroomID, userID: userID}
+
+	s.mux.Lock()
+	state, ok := s.typing[key]
+	if ok {
+		state.timer.Stop()
+		delete(s.typing, key)
+	}
+	s.mux.Unlock()
+
+	if ok {
+		s.notify(TypingEvent{ChatroomID: chatroomID, UserID: userID, Username: state.username, Typing: false})
+	}
+}
+
+// StopAll clears every typing indicator of a user, e.g. when they disconnect
+func (s *TypingService) StopAll(userID uint) {
+	s.mux.Lock()
+	var stopped []TypingEvent
+	for key, state := range s.typing {
+		if key.userID == userID {
+			state.timer.Stop()
+			delete(s.typing, key)
+			stopped = append(stopped, TypingEvent{ChatroomID: key.chatroomID, UserID: userID, Username: state.username, Typing: false})
+		}
+	}
+	s.mux.Unlock()
+
+	for _, event := range stopped {
+		s.notify(event)
+	}
+}
+
+// expire clears a typing indicator whose timer fired, unless it was replaced in the meantime
+func (s *TypingService) expire(key typingKey, state *typingState) {
+	s.mux.Lock()
+	if s.typing[key] != state {
+		s.mux.Unlock()
+		return
+	}
+	delete(s.typing, key)
+	s.mux.Unlock()
+
+	s.notify(TypingEvent{ChatroomID: key.chatroomID, UserID: key.userID, Username: state.username, Typing: false})
+}
+
+// notify passes an event to every listener
+func (s *TypingService) notify(event TypingEvent) {
+	s.mux.Lock()
+	listeners := append([]func(TypingEvent){}, s.listeners...)
+	s.mux.Unlock()
+
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
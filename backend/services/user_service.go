@@ -5,19 +5,21 @@ import (
 	"time"
 
 	"github.com/ginchat/models"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
 // UserService handles business logic related to users
 type UserService struct {
-	DB *gorm.DB
+	DB      *gorm.DB
+	Hashers *PasswordHashers
 }
 
 // NewUserService creates a new UserService
-func NewUserService(db *gorm.DB) *UserService {
+func NewUserService(db *gorm.DB, hashers *PasswordHashers) *UserService {
 	return &UserService{
-		DB: db,
+		DB:      db,
+		Hashers: hashers,
 	}
 }
 
@@ -55,8 +57,19 @@ func (s *UserService) Register(username, email, password, role string) (*models.
 	return &user, nil
 }
 
-// Login authenticates a user
+// Login authenticates a user and marks them logged in
 func (s *UserService) Login(email, password string) (*models.User, error) {
+	user, err := s.Authenticate(email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	s.MarkLoggedIn(user)
+	return user, nil
+}
+
+// Authenticate checks a user's credentials and account state without logging them in
+func (s *UserService) Authenticate(email, password string) (*models.User, error) {
 	// Find user by email
 	var user models.User
 	if result := s.DB.Where("email = ?", email).First(&user); result.Error != nil {
@@ -64,19 +77,36 @@ func (s *UserService) Login(email, password string) (*models.User, error) {
 	}
 
 	// Check password
-	if !s.VerifyPassword(user.Password, password) {
+	valid, rehash := s.Hashers.Verify(user.Password, password)
+	if !valid {
 		return nil, errors.New("invalid email or password")
 	}
 
+	// Upgrade a hash made with an outdated algorithm or parameters while the password is at hand
+	if rehash {
+		s.rehashPassword(&user, password)
+	}
+
+	// Check account state only once the caller has proven they own the account
+	if user.Disabled {
+		return nil, errors.New("account disabled")
+	}
+	if user.PasswordResetRequired {
+		return nil, errors.New("password reset required")
+	}
+
+	return &user, nil
+}
+
+// MarkLoggedIn records a successful login on the user
+func (s *UserService) MarkLoggedIn(user *models.User) {
 	// Update user status
 	user.IsLogin = true
 	user.Status = "online"
 	now := time.Now()
 	user.LastLoginAt = &now
 	user.Heartbeat = &now
-	s.DB.Save(&user)
-
-	return &user, nil
+	s.DB.Save(user)
 }
 
 // Logout updates the user's status
@@ -114,40 +144,171 @@ func (s *UserService) UpdateUser(user *models.User) error {
 	return nil
 }
 
-// HashPassword hashes a password using bcrypt
-func (s *UserService) HashPassword(password string) (string, error) {
-	// Use a higher cost factor for better security (12 is a good balance between security and performance)
-	cost := 12
-	
-	// Generate a salt and hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+// SearchUsers returns a page of users matching a username or email search, role and account status
+func (s *UserService) SearchUsers(search, role, status string, page, pageSize int) ([]models.User, int64, error) {
+	query := s.DB.Model(&models.User{})
+	if search != "" {
+		pattern := "%" + search + "%"
+		query = query.Where("username LIKE ? OR email LIKE ?", pattern, pattern)
+	}
+	if role != "" {
+		query = query.Where("role = ?", role)
+	}
+	switch status {
+	case "disabled":
+		query = query.Where("disabled = ?", true)
+	case "active":
+		query = query.Where("disabled = ?", false)
+	}
+
+	var total int64
+	if result := query.Count(&total); result.Error != nil {
+		return nil, 0, errors.New("failed to fetch users")
+	}
+
+	var users []models.User
+	result := query.Order("user_id").Offset((page - 1) * pageSize).Limit(pageSize).Find(&users)
+	if result.Error != nil {
+		return nil, 0, errors.New("failed to fetch users")
+	}
+	return users, total, nil
+}
+
+// SetRole changes a user's role
+func (s *UserService) SetRole(userID uint, role string) (*models.User, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if result := s.DB.Model(user).Update("role", role); result.Error != nil {
+		return nil, errors.New("failed to update user")
+	}
+	return user, nil
+}
+
+// SetDisabled disables or re-enables a user's account
+func (s *UserService) SetDisabled(userID uint, disabled bool) (*models.User, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var disabledAt *time.Time
+	if disabled {
+		now := time.Now()
+		disabledAt = &now
+	}
+
+	result := s.DB.Model(user).Updates(map[string]interface{}{
+		"disabled":    disabled,
+		"disabled_at": disabledAt,
+	})
+	if result.Error != nil {
+		return nil, errors.New("failed to update user")
+	}
+	return user, nil
+}
+
+// RequirePasswordReset makes a user reset their password before they can log in again
+func (s *UserService) RequirePasswordReset(userID uint) (*models.User, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if result := s.DB.Model(user).Update("password_reset_required", true); result.Error != nil {
+		return nil, errors.New("failed to update user")
+	}
+	return user, nil
+}
+
+// ChangePassword sets a new password for a user after checking their current one
+func (s *UserService) ChangePassword(user *models.User, currentPassword, newPassword string) error {
+	if !s.VerifyPassword(user.Password, currentPassword) {
+		return errors.New("current password is incorrect")
+	}
+
+	hashedPassword, err := s.HashPassword(newPassword)
 	if err != nil {
-		return "", err
+		return errors.New("failed to hash password")
 	}
-	
-	return string(hashedPassword), nil
+
+	result := s.DB.Model(user).Updates(map[string]interface{}{
+		"password":                hashedPassword,
+		"password_reset_required": false,
+	})
+	if result.Error != nil {
+		return errors.New("failed to update password")
+	}
+	return nil
 }
 
-// VerifyPassword checks if a password matches a hash
+// HashPassword hashes a password with the configured algorithm (the salt is included in the hash)
+func (s *UserService) HashPassword(password string) (string, error) {
+	return s.Hashers.Hash(password)
+}
+
+// VerifyPassword checks if a password matches a hash of any supported algorithm
 func (s *UserService) VerifyPassword(hashedPassword, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+	valid, _ := s.Hashers.Verify(hashedPassword, password)
+	return valid
 }
 
-// IsHashedPassword checks if a password is already hashed with bcrypt
+// IsHashedPassword checks if a password is already hashed with a supported algorithm
 func (s *UserService) IsHashedPassword(password string) bool {
-	return len(password) > 4 && (password[:4] == "$2a$" || password[:4] == "$2b$" || password[:4] == "$2y$")
+	return s.Hashers.IsHashed(password)
+}
+
+// rehashPassword replaces a user's password hash with one made with the
+// current algorithm and parameters. A failure only means the upgrade is
+// retried on the next login.
+func (s *UserService) rehashPassword(user *models.User, password string) {
+	hashedPassword, err := s.HashPassword(password)
+	if err != nil {
+		logrus.Warnf("Failed to rehash password for user %d: %v", user.UserID, err)
+		return
+	}
+
+	// Only replace the hash that was verified, in case the password changed meanwhile
+	result := s.DB.Model(&models.User{}).
+		Where("user_id = ? AND password = ?", user.UserID, user.Password).
+		Update("password", hashedPassword)
+	if result.Error != nil {
+		logrus.Warnf("Failed to rehash password for user %d: %v", user.UserID, result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		user.Password = hashedPassword
+	}
 }
 
 // ToResponse converts a User to a UserResponse
 func (s *UserService) ToResponse(user *models.User) models.UserResponse {
 	return models.UserResponse{
-		UserID:    user.UserID,
-		Username:  user.Username,
-		Email:     user.Email,
-		Role:      user.Role,
-		Status:    user.Status,
-		AvatarURL: user.AvatarURL,
-		CreatedAt: user.CreatedAt,
+		UserID:           user.UserID,
+		Username:         user.Username,
+		Email:            user.Email,
+		Role:             user.Role,
+		Status:           user.Status,
+		AvatarURL:        user.AvatarURL,
+		EmailVerified:    user.EmailVerified,
+		TwoFactorEnabled: user.TwoFactorEnabled,
+		CreatedAt:        user.CreatedAt,
+	}
+}
+
+// ToAdminResponse converts a User to an AdminUserResponse
+func (s *UserService) ToAdminResponse(user *models.User) models.AdminUserResponse {
+	return models.AdminUserResponse{
+		UserResponse:          s.ToResponse(user),
+		IsLogin:               user.IsLogin,
+		LastLoginAt:           user.LastLoginAt,
+		Disabled:              user.Disabled,
+		DisabledAt:            user.DisabledAt,
+		PasswordResetRequired: user.PasswordResetRequired,
+		FailedLoginAttempts:   user.FailedLoginAttempts,
+		LockedUntil:           user.LockedUntil,
+		UpdatedAt:             user.UpdatedAt,
 	}
 }
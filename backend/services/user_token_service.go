@@ -0,0 +1,190 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ginchat/models"
+	"gorm.io/gorm"
+)
+
+// Purposes of single-use user tokens
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposeTwoFactor         = "two_factor"
+	TokenPurposeEmailChange       = "email_change"
+	TokenPurposeOIDCLogin         = "oidc_login"
+	TokenPurposeStreamTicket      = "stream_ticket"
+)
+
+// UserTokenService issues and redeems single-use tokens sent to users by email
+type UserTokenService struct {
+	DB *gorm.DB
+}
+
+// NewUserTokenService creates a new UserTokenService
+func NewUserTokenService(db *gorm.DB) *UserTokenService {
+	return &UserTokenService{
+		DB: db,
+	}
+}
+
+// Issue creates a token for a user and purpose, invalidating the user's
+// earlier unused tokens for the same purpose
+func (s *UserTokenService) Issue(userID uint, purpose, data string, ttl time.Duration) (string, error) {
+	return s.issue(userID, purpose, data, ttl, true)
+}
+
+// IssueAdditional creates a token for a user and purpose, leaving the user's
+// earlier tokens for the purpose valid, for tokens that several clients of
+// the same user hold at once
+func (s *UserTokenService) IssueAdditional(userID uint, purpose, data string, ttl time.Duration) (string, error) {
+	return s.issue(userID, purpose, data, ttl, false)
+}
+
+// DeleteSpent deletes the used and expired tokens for a purpose
+func (s *UserTokenService) DeleteSpent(purpose string) error {
+	result := s.DB.Where("purpose = ? AND (used_at IS NOT NULL OR expires_at < ?)", purpose, time.Now()).
+		Delete(&models.UserToken{})
+	if result.Error != nil {
+		return errors.New("failed to delete tokens")
+	}
+	return nil
+}
+
+// issue creates a token, optionally invalidating the user's earlier unused tokens for the purpose
+func (s *UserTokenService) issue(userID uint, purpose, data string, ttl time.Duration, invalidate bool) (string, error) {
+	rawToken, err := randomToken(32)
+	if err != nil {
+		return "", errors.New("failed to generate token")
+	}
+
+	now := time.Now()
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if invalidate {
+			result := tx.Model(&models.UserToken{}).
+				Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+				Update("used_at", now)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+
+		return tx.Create(&models.UserToken{
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: hashToken(rawToken),
+			Data:      data,
+			ExpiresAt: now.Add(ttl),
+			CreatedAt: now,
+		}).Error
+	})
+	if err != nil {
+		return "", errors.New("failed to store token")
+	}
+
+	return rawToken, nil
+}
+
+// Find returns a token that can still be redeemed, without redeeming it
+func (s *UserTokenService) Find(rawToken, purpose string) (*models.UserToken, error) {
+	var token models.UserToken
+	result := s.DB.Where("token_hash = ? AND purpose = ?", hashToken(rawToken), purpose).First(&token)
+	if result.Error != nil || token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+		return nil, errors.New("invalid or expired token")
+	}
+	return &token, nil
+}
+
+// Consume redeems a token for a purpose. A token can be redeemed only once.
+func (s *UserTokenService) Consume(rawToken, purpose string) (*models.UserToken, error) {
+	token, err := s.Find(rawToken, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	// Mark the token used; a concurrent redemption leaves no row to update
+	now := time.Now()
+	result := s.DB.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL", token.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, errors.New("failed to redeem token")
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.New("invalid or expired token")
+	}
+
+	token.UsedAt = &now
+	return token, nil
+}
+
+// Attempt counts an attempt to use a token before the caller checks the code
+// that goes with it. Only maxAttempts attempts are counted, even when they run
+// concurrently; further ones return an error without being checked.
+func (s *UserTokenService) Attempt(token *models.UserToken, maxAttempts int) error {
+	result := s.DB.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL AND attempts < ?", token.ID, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return errors.New("failed to update token")
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("invalid or expired token")
+	}
+	return nil
+}
+
+// RecordFailure invalidates a token after a failed attempt if it was the
+// last of maxAttempts counted by Attempt. The decision is made by the update
+// itself, so concurrent failures cannot miss it.
+func (s *UserTokenService) RecordFailure(token *models.UserToken, maxAttempts int) error {
+	result := s.DB.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL", token.ID).
+		Update("used_at", gorm.Expr("CASE WHEN attempts >= ? THEN ? ELSE used_at END", maxAttempts, time.Now()))
+	if result.Error != nil {
+		return errors.New("failed to update token")
+	}
+	return nil
+}
+
+// Throttle returns how long a user has to wait before another token is issued
+// for a purpose, given the minimum interval between two tokens and the
+// maximum number of tokens per day. It returns zero if a token can be issued now.
+func (s *UserTokenService) Throttle(userID uint, purpose string, interval time.Duration, dailyLimit int) (time.Duration, error) {
+	now := time.Now()
+	count, latest, err := s.Recent(userID, purpose, now.Add(-24*time.Hour))
+	if err != nil {
+		return 0, err
+	}
+	if wait := latest.Add(interval).Sub(now); count > 0 && wait > 0 {
+		return wait, nil
+	}
+	if count >= int64(dailyLimit) {
+		return 24 * time.Hour, nil
+	}
+	return 0, nil
+}
+
+// Recent returns how many tokens were issued to a user for a purpose since a
+// time, and when the latest of them was issued
+func (s *UserTokenService) Recent(userID uint, purpose string, since time.Time) (int64, time.Time, error) {
+	var count int64
+	result := s.DB.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND created_at > ?", userID, purpose, since).
+		Count(&count)
+	if result.Error != nil {
+		return 0, time.Time{}, errors.New("failed to fetch tokens")
+	}
+	if count == 0 {
+		return 0, time.Time{}, nil
+	}
+
+	var latest models.UserToken
+	result = s.DB.Where("user_id = ? AND purpose = ?", userID, purpose).Order("created_at DESC").First(&latest)
+	if result.Error != nil {
+		return 0, time.Time{}, errors.New("failed to fetch tokens")
+	}
+	return count, latest.CreatedAt, nil
+}
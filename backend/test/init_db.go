@@ -112,7 +112,11 @@ func initMySQL() (*gorm.DB, error) {
 	log.Println("Creating test users")
 
 	// Create a user service
-	userService := services.NewUserService(db)
+	passwordHashers, err := services.NewPasswordHashers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure password hashing: %v", err)
+	}
+	userService := services.NewUserService(db, passwordHashers)
 
 	// Create test users using the user service
 	testUsers := []struct {
@@ -32,22 +32,16 @@ type JWTClaims struct {
 
 // GenerateJWT generates a new JWT token for a user
 func GenerateJWT(userID uint, username, email, role string) (string, error) {
-	// Get JWT secret from environment
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return "", errors.New("JWT_SECRET environment variable not set")
-	}
+	tokenString, _, err := IssueJWT(userID, username, email, role)
+	return tokenString, err
+}
 
+// IssueJWT generates a new JWT token for a user and also returns its claims
+func IssueJWT(userID uint, username, email, role string) (string, *JWTClaims, error) {
 	// Get JWT expiration from environment
-	jwtExpiration := os.Getenv("JWT_EXPIRATION")
-	if jwtExpiration == "" {
-		jwtExpiration = "24h" // Default to 24 hours
-	}
-
-	// Parse expiration duration
-	expirationDuration, err := time.ParseDuration(jwtExpiration)
+	expirationDuration, err := JWTExpiration()
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	// Generate a unique token ID (jti)
@@ -70,6 +64,28 @@ func GenerateJWT(userID uint, username, email, role string) (string, error) {
 		},
 	}
 
+	// Sign with the shared secret, or with the current key pair identified by kid
+	var tokenString string
+	if JWTAlgorithm() == AlgorithmHS256 {
+		tokenString, err = signHS256(claims)
+	} else {
+		tokenString, err = signWithKey(claims)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	return tokenString, &claims, nil
+}
+
+// signHS256 signs claims with JWT_SECRET
+func signHS256(claims JWTClaims) (string, error) {
+	// Get JWT secret from environment
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return "", errors.New("JWT_SECRET environment variable not set")
+	}
+
 	// Create token
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
@@ -78,29 +94,81 @@ func GenerateJWT(userID uint, username, email, role string) (string, error) {
 	token.Header["alg"] = "HS256"
 
 	// Sign token with secret
-	tokenString, err := token.SignedString([]byte(jwtSecret))
+	return token.SignedString([]byte(jwtSecret))
+}
+
+// signWithKey signs claims with the current asymmetric signing key
+func signWithKey(claims JWTClaims) (string, error) {
+	keys, err := Keys()
+	if err != nil {
+		return "", err
+	}
+	key, err := keys.Current()
 	if err != nil {
 		return "", err
 	}
 
-	return tokenString, nil
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["typ"] = "JWT"
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.PrivateKey)
 }
 
-// ValidateJWT validates a JWT token and returns the claims
-func ValidateJWT(tokenString string) (*JWTClaims, error) {
-	// Get JWT secret from environment
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return nil, errors.New("JWT_SECRET environment variable not set")
+// JWTExpiration returns how long an access token is valid for
+func JWTExpiration() (time.Duration, error) {
+	jwtExpiration := os.Getenv("JWT_EXPIRATION")
+	if jwtExpiration == "" {
+		jwtExpiration = "15m" // Short-lived; clients renew with a refresh token
+	}
+	return time.ParseDuration(jwtExpiration)
+}
+
+// acceptHS256 reports whether tokens signed with JWT_SECRET are accepted: always
+// when HS256 is the signing algorithm, and after switching to key pairs only
+// until JWT_ACCEPT_HS256_UNTIL (RFC 3339), so users holding HS256 tokens are
+// not logged out mid-migration but the shared secret stops minting tokens after it
+func acceptHS256() bool {
+	if JWTAlgorithm() == AlgorithmHS256 {
+		return true
 	}
 
+	until, err := time.Parse(time.RFC3339, os.Getenv("JWT_ACCEPT_HS256_UNTIL"))
+	if err != nil {
+		return false
+	}
+	return time.Now().Before(until)
+}
+
+// ValidateJWT validates a JWT token and returns the claims
+func ValidateJWT(tokenString string) (*JWTClaims, error) {
 	// Parse token
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		// Validate signing method and pick the verification key
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			if !acceptHS256() {
+				return nil, errors.New("HS256 tokens are no longer accepted")
+			}
+			jwtSecret := os.Getenv("JWT_SECRET")
+			if jwtSecret == "" {
+				return nil, errors.New("JWT_SECRET environment variable not set")
+			}
+			return []byte(jwtSecret), nil
+		case *jwt.SigningMethodRSA, *signingMethodEdDSA:
+			keys, err := Keys()
+			if err != nil {
+				return nil, err
+			}
+			kid, _ := token.Header["kid"].(string)
+			key := keys.Lookup(kid)
+			if key == nil || key.Algorithm != token.Method.Alg() {
+				return nil, errors.New("unknown signing key")
+			}
+			return key.PublicKey, nil
+		default:
 			return nil, errors.New("unexpected signing method")
 		}
-		return []byte(jwtSecret), nil
 	})
 
 	if err != nil {
@@ -0,0 +1,531 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWT signing algorithms
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// rsaKeyBits is the size of generated RSA signing keys
+const rsaKeyBits = 2048
+
+// JWKSMaxAge is how long clients may cache the published key set
+const JWKSMaxAge = 5 * time.Minute
+
+// unknownKeyReloadInterval limits how often a token with an unknown key ID
+// makes the key directory be re-read
+const unknownKeyReloadInterval = 10 * time.Second
+
+// PEM headers recording when a key was generated and when it starts signing.
+// They travel with the file, unlike its modification time.
+const (
+	pemHeaderCreatedAt   = "Created-At"
+	pemHeaderActivatesAt = "Activates-At"
+)
+
+// SigningKey is an asymmetric key pair used to sign and verify tokens
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey crypto.PrivateKey
+	PublicKey  crypto.PublicKey
+	CreatedAt  time.Time
+	// ActivatesAt is when the key starts signing new tokens; until then it
+	// is only published, so every instance and JWKS client knows it first
+	ActivatesAt time.Time
+
+	path string
+}
+
+// JSONWebKey is the public part of a SigningKey in JWK format
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA exponent
+	Curve     string `json:"crv,omitempty"` // OKP or EC curve
+	X         string `json:"x,omitempty"`   // OKP public key, or EC x coordinate
+	Y         string `json:"y,omitempty"`   // EC y coordinate
+}
+
+// JSONWebKeySet is the document served at /.well-known/jwks.json
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// PublicKey decodes the public key of an RSA, EC or Ed25519 JSON Web Key
+func (k JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, errors.New("invalid RSA modulus")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported EC curve " + k.Curve)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil {
+			return nil, errors.New("invalid EC coordinates")
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if k.Curve != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errors.New("unsupported key type " + k.KeyType)
+}
+
+// KeyStore holds the signing keys kept as PEM files named <kid>.pem in a
+// directory shared by every instance. A rotated key is published for
+// PublishAhead before it signs, then the most recently activated key signs
+// new tokens on every instance; superseded keys keep verifying tokens for the
+// retention period, after which their files are deleted.
+type KeyStore struct {
+	// Dir is the directory holding the private keys
+	Dir string
+	// Algorithm is the algorithm new tokens are signed with
+	Algorithm string
+	// RotateEvery is the age at which the signing key is replaced by a new one
+	RotateEvery time.Duration
+	// Retention is how long a superseded key keeps verifying tokens
+	Retention time.Duration
+	// ReloadInterval is how often the directory is re-read and rotation is checked
+	ReloadInterval time.Duration
+
+	keys []*SigningKey // Most recently activated first
+	mux  sync.RWMutex
+
+	lastLookupReload time.Time
+	lookupMux        sync.Mutex
+}
+
+var (
+	keyStore     *KeyStore
+	keyStoreErr  error
+	keyStoreOnce sync.Once
+)
+
+// JWTAlgorithm returns the algorithm new tokens are signed with
+func JWTAlgorithm() string {
+	switch algorithm := os.Getenv("JWT_SIGNING_ALG"); algorithm {
+	case AlgorithmRS256, AlgorithmEdDSA:
+		return algorithm
+	default:
+		return AlgorithmHS256
+	}
+}
+
+// Keys returns the process-wide KeyStore, loading it from the environment on first use
+func Keys() (*KeyStore, error) {
+	keyStoreOnce.Do(func() {
+		dir := os.Getenv("JWT_KEYS_DIR")
+		if dir == "" {
+			keyStoreErr = errors.New("JWT_KEYS_DIR environment variable not set")
+			return
+		}
+
+		// Superseded keys must outlive every token they signed
+		retention := GetEnvDuration("JWT_KEY_RETENTION", 24*time.Hour)
+		if accessTTL, err := JWTExpiration(); err == nil && retention < accessTTL {
+			retention = accessTTL
+		}
+
+		keyStore, keyStoreErr = NewKeyStore(
+			dir,
+			JWTAlgorithm(),
+			GetEnvDuration("JWT_KEY_ROTATION", 30*24*time.Hour),
+			retention,
+		)
+	})
+	return keyStore, keyStoreErr
+}
+
+// NewKeyStore loads the keys in a directory, generating a signing key if none is current
+func NewKeyStore(dir, algorithm string, rotateEvery, retention time.Duration) (*KeyStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	ks := &KeyStore{
+		Dir:            dir,
+		Algorithm:      algorithm,
+		RotateEvery:    rotateEvery,
+		Retention:      retention,
+		ReloadInterval: GetEnvDuration("JWT_KEY_RELOAD_INTERVAL", time.Minute),
+	}
+	// Unreadable key files are skipped rather than failing the whole set;
+	// Start reports them
+	ks.Reload()
+	if err := ks.rotateIfDue(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// PublishAhead is how long a new key is published before it signs: one
+// reload for every instance to load it, plus the JWKS cache lifetime
+func (ks *KeyStore) PublishAhead() time.Duration {
+	return ks.ReloadInterval + JWKSMaxAge
+}
+
+// Start periodically reloads the key directory, picking up keys rotated by
+// other instances, and rotates the signing key when it is due. Errors are
+// passed to onError; they do not stop the reloads.
+func (ks *KeyStore) Start(onError func(error)) {
+	check := func() {
+		if err := ks.Reload(); err != nil {
+			onError(err)
+		}
+		if err := ks.rotateIfDue(); err != nil {
+			onError(err)
+		}
+	}
+
+	go func() {
+		check()
+
+		ticker := time.NewTicker(ks.ReloadInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			check()
+		}
+	}()
+}
+
+// Current returns the key new tokens are signed with: the most recently
+// activated key for the algorithm. Every instance reads the same activation
+// times from the key files, so they all switch keys at the same moment.
+func (ks *KeyStore) Current() (*SigningKey, error) {
+	ks.mux.RLock()
+	defer ks.mux.RUnlock()
+
+	now := time.Now()
+	for _, key := range ks.keys {
+		if key.Algorithm == ks.Algorithm && !key.ActivatesAt.After(now) {
+			return key, nil
+		}
+	}
+	return nil, errors.New("no signing key available")
+}
+
+// pending reports whether a key for the algorithm is published but not signing yet
+func (ks *KeyStore) pending() bool {
+	ks.mux.RLock()
+	defer ks.mux.RUnlock()
+
+	now := time.Now()
+	for _, key := range ks.keys {
+		if key.Algorithm == ks.Algorithm && key.ActivatesAt.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Key returns the key with the given ID if it is still valid for verification
+func (ks *KeyStore) Key(kid string) *SigningKey {
+	ks.mux.RLock()
+	defer ks.mux.RUnlock()
+
+	for _, key := range ks.keys {
+		if key.ID == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// Lookup returns the key with the given ID like Key, but re-reads the key
+// directory once when the ID is unknown, in case another instance has just
+// rotated. The reloads are rate limited, so unknown IDs cannot force one per request.
+func (ks *KeyStore) Lookup(kid string) *SigningKey {
+	if key := ks.Key(kid); key != nil {
+		return key
+	}
+
+	ks.lookupMux.Lock()
+	if time.Since(ks.lastLookupReload) < unknownKeyReloadInterval {
+		ks.lookupMux.Unlock()
+		return nil
+	}
+	ks.lastLookupReload = time.Now()
+	ks.lookupMux.Unlock()
+
+	ks.Reload()
+	return ks.Key(kid)
+}
+
+// JWKS returns the public keys valid for verification
+func (ks *KeyStore) JWKS() JSONWebKeySet {
+	ks.mux.RLock()
+	defer ks.mux.RUnlock()
+
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(ks.keys))}
+	for _, key := range ks.keys {
+		jwk := JSONWebKey{
+			KeyID:     key.ID,
+			Use:       "sig",
+			Algorithm: key.Algorithm,
+		}
+		switch publicKey := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// Reload re-reads the key directory and deletes the files of keys past
+// their retention. Files that cannot be read are skipped, and reported in
+// the returned error once the other keys are loaded.
+func (ks *KeyStore) Reload() error {
+	paths, err := filepath.Glob(filepath.Join(ks.Dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*SigningKey, 0, len(paths))
+	var skipped []error
+	for _, path := range paths {
+		key, err := loadSigningKey(path)
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("skipped key file %s: %w", path, err))
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ActivatesAt.After(keys[j].ActivatesAt)
+	})
+
+	// A key is superseded when the next key activates
+	now := time.Now()
+	valid := make([]*SigningKey, 0, len(keys))
+	for i, key := range keys {
+		if i > 0 && now.Sub(keys[i-1].ActivatesAt) > ks.Retention {
+			if err := os.Remove(key.path); err != nil && !os.IsNotExist(err) {
+				skipped = append(skipped, fmt.Errorf("failed to delete expired key file %s: %w", key.path, err))
+			}
+			continue
+		}
+		valid = append(valid, key)
+	}
+
+	ks.mux.Lock()
+	ks.keys = valid
+	ks.mux.Unlock()
+	return errors.Join(skipped...)
+}
+
+// Rotate generates a new signing key that starts signing at activatesAt
+func (ks *KeyStore) Rotate(activatesAt time.Time) (*SigningKey, error) {
+	var privateKey crypto.PrivateKey
+	switch ks.Algorithm {
+	case AlgorithmRS256:
+		rsaKey, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		privateKey = rsaKey
+	case AlgorithmEdDSA:
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		privateKey = edKey
+	default:
+		return nil, errors.New("signing algorithm does not use key pairs")
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	kidBytes := make([]byte, 8)
+	if _, err := rand.Read(kidBytes); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	kid := now.Format("20060102") + "-" + hex.EncodeToString(kidBytes)
+
+	block := &pem.Block{
+		Type: "PRIVATE KEY",
+		Headers: map[string]string{
+			pemHeaderCreatedAt:   now.Format(time.RFC3339),
+			pemHeaderActivatesAt: activatesAt.UTC().Format(time.RFC3339),
+		},
+		Bytes: der,
+	}
+
+	// Write to a temporary file first so other instances never read a partial key
+	path := filepath.Join(ks.Dir, kid+".pem")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+
+	ks.Reload()
+	if key := ks.Key(kid); key != nil {
+		return key, nil
+	}
+	return nil, errors.New("failed to load the new signing key")
+}
+
+// rotateIfDue publishes the next signing key PublishAhead before the current
+// one is due for rotation. Without any current key, for instance on first
+// start, a new key signs right away since no token can depend on an older one.
+func (ks *KeyStore) rotateIfDue() error {
+	if ks.Algorithm == AlgorithmHS256 {
+		return nil
+	}
+
+	current, err := ks.Current()
+	if err != nil {
+		_, err = ks.Rotate(time.Now())
+		return err
+	}
+	if ks.pending() || time.Since(current.ActivatesAt) < ks.RotateEvery-ks.PublishAhead() {
+		return nil
+	}
+	_, err = ks.Rotate(time.Now().Add(ks.PublishAhead()))
+	return err
+}
+
+// loadSigningKey reads a PEM private key; the file name is the key ID and
+// the PEM headers its creation and activation times
+func loadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM key file " + path)
+	}
+
+	var privateKey interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{
+		ID:         strings.TrimSuffix(filepath.Base(path), ".pem"),
+		PrivateKey: privateKey,
+		path:       path,
+	}
+	if err := key.readTimes(block.Headers); err != nil {
+		return nil, err
+	}
+
+	switch k := privateKey.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm = AlgorithmRS256
+		key.PublicKey = &k.PublicKey
+	case ed25519.PrivateKey:
+		key.Algorithm = AlgorithmEdDSA
+		key.PublicKey = k.Public()
+	default:
+		return nil, errors.New("unsupported key type in " + path)
+	}
+	return key, nil
+}
+
+// readTimes sets the creation and activation times from PEM headers. Keys
+// written before the headers existed were active from creation; their
+// creation date is taken from the key ID.
+func (k *SigningKey) readTimes(headers map[string]string) error {
+	if created, ok := headers[pemHeaderCreatedAt]; ok {
+		createdAt, err := time.Parse(time.RFC3339, created)
+		if err != nil {
+			return errors.New("invalid " + pemHeaderCreatedAt + " header")
+		}
+		k.CreatedAt = createdAt
+	} else {
+		date, _, _ := strings.Cut(k.ID, "-")
+		createdAt, err := time.Parse("20060102", date)
+		if err != nil {
+			return errors.New("missing " + pemHeaderCreatedAt + " header")
+		}
+		k.CreatedAt = createdAt
+	}
+
+	k.ActivatesAt = k.CreatedAt
+	if activates, ok := headers[pemHeaderActivatesAt]; ok {
+		activatesAt, err := time.Parse(time.RFC3339, activates)
+		if err != nil {
+			return errors.New("invalid " + pemHeaderActivatesAt + " header")
+		}
+		k.ActivatesAt = activatesAt
+	}
+	return nil
+}
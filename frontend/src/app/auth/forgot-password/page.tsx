@@ -0,0 +1,14 @@
+'use client';
+
+import Layout from '@/components/Layout';
+import ForgotPasswordForm from '@/components/auth/ForgotPasswordForm';
+
+export default function ForgotPasswordPage() {
+  return (
+    <Layout>
+      <div className="flex min-h-screen flex-col items-center justify-center p-24">
+        <ForgotPasswordForm />
+      </div>
+    </Layout>
+  );
+}
@@ -8,7 +8,7 @@ import AlertMessage from '@/components/ui/AlertMessage';
 
 export default function LoginPage() {
   const searchParams = useSearchParams();
-  const [sessionAlert, setSessionAlert] = useState<{ type: 'warning' | 'info', message: string } | null>(null);
+  const [sessionAlert, setSessionAlert] = useState<{ type: 'success' | 'warning' | 'info', message: string } | null>(null);
 
   useEffect(() => {
     // Check if there's a session parameter
@@ -23,6 +23,36 @@ export default function LoginPage() {
         type: 'info',
         message: 'You have been successfully logged out.'
       });
+    } else if (session === 'verified') {
+      setSessionAlert({
+        type: 'success',
+        message: 'Your email address has been verified. You can now log in.'
+      });
+    } else if (session === 'verify_failed') {
+      setSessionAlert({
+        type: 'warning',
+        message: 'This verification link is invalid or has expired. Log in to request a new one.'
+      });
+    } else if (session === 'email_changed') {
+      setSessionAlert({
+        type: 'success',
+        message: 'Your email address has been changed. Use the new address to log in.'
+      });
+    } else if (session === 'email_change_failed') {
+      setSessionAlert({
+        type: 'warning',
+        message: 'This confirmation link is invalid or has expired, or the address is already in use. Your email address was not changed.'
+      });
+    } else if (session === 'oidc_failed') {
+      setSessionAlert({
+        type: 'warning',
+        message: 'Signing in with the external provider failed. Please try again.'
+      });
+    } else if (session === 'oidc_email_conflict') {
+      setSessionAlert({
+        type: 'warning',
+        message: 'An account with this email already exists. Log in with your password to use it.'
+      });
     }
   }, [searchParams]);
 
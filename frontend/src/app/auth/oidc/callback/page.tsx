@@ -0,0 +1,17 @@
+'use client';
+
+import { useSearchParams } from 'next/navigation';
+import Layout from '@/components/Layout';
+import OIDCCallback from '@/components/auth/OIDCCallback';
+
+export default function OIDCCallbackPage() {
+  const searchParams = useSearchParams();
+
+  return (
+    <Layout>
+      <div className="flex min-h-screen flex-col items-center justify-center p-24">
+        <OIDCCallback code={searchParams.get('code') || ''} />
+      </div>
+    </Layout>
+  );
+}
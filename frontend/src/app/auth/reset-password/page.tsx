@@ -0,0 +1,17 @@
+'use client';
+
+import { useSearchParams } from 'next/navigation';
+import Layout from '@/components/Layout';
+import ResetPasswordForm from '@/components/auth/ResetPasswordForm';
+
+export default function ResetPasswordPage() {
+  const searchParams = useSearchParams();
+
+  return (
+    <Layout>
+      <div className="flex min-h-screen flex-col items-center justify-center p-24">
+        <ResetPasswordForm token={searchParams.get('token') || ''} />
+      </div>
+    </Layout>
+  );
+}
@@ -0,0 +1,103 @@
+import { useState } from 'react';
+import Link from 'next/link';
+import { useForm } from 'react-hook-form';
+import { authAPI } from '@/services/api';
+import AlertMessage from '@/components/ui/AlertMessage';
+
+type ForgotPasswordFormData = {
+  email: string;
+};
+
+type AlertState = {
+  type: 'success' | 'error' | 'warning' | 'info';
+  message: string;
+};
+
+const ForgotPasswordForm = () => {
+  const [isLoading, setIsLoading] = useState(false);
+  const [alert, setAlert] = useState<AlertState | null>(null);
+
+  const {
+    register,
+    handleSubmit,
+    formState: { errors },
+  } = useForm<ForgotPasswordFormData>();
+
+  const onSubmit = async (data: ForgotPasswordFormData) => {
+    setIsLoading(true);
+    setAlert(null);
+
+    try {
+      const response = await authAPI.forgotPassword(data.email);
+      setAlert({ type: 'success', message: response.data.message });
+    } catch (err: any) {
+      setAlert({
+        type: 'error',
+        message: err.response?.data?.error || 'Network error. Please check your connection and try again.'
+      });
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  return (
+    <div className="w-full max-w-md p-8 space-y-8 bg-white dark:bg-gray-800 rounded-lg shadow-md">
+      <div className="text-center">
+        <h1 className="text-3xl font-bold">Forgot your password?</h1>
+        <p className="mt-2 text-gray-600 dark:text-gray-400">Enter your email and we'll send you a reset link</p>
+      </div>
+
+      {alert && (
+        <AlertMessage
+          type={alert.type}
+          message={alert.message}
+          onClose={() => setAlert(null)}
+        />
+      )}
+
+      <form className="mt-8 space-y-6" onSubmit={handleSubmit(onSubmit)}>
+        <div>
+          <label htmlFor="email" className="block text-sm font-medium text-gray-700 dark:text-gray-300">
+            Email
+          </label>
+          <input
+            id="email"
+            type="email"
+            {...register('email', {
+              required: 'Email is required',
+              pattern: {
+                value: /^[A-Z0-9._%+-]+@[A-Z0-9.-]+\.[A-Z]{2,}$/i,
+                message: 'Invalid email address',
+              }
+            })}
+            className="mt-1 block w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm focus:outline-none focus:ring-primary-500 focus:border-primary-500"
+          />
+          {errors.email && (
+            <p className="mt-1 text-sm text-red-600">{errors.email.message}</p>
+          )}
+        </div>
+
+        <div>
+          <button
+            type="submit"
+            disabled={isLoading}
+            className="w-full flex justify-center items-center py-2 px-4 border border-transparent rounded-md shadow-sm text-sm font-medium text-white bg-primary-600 hover:bg-primary-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-primary-500 disabled:opacity-50 transition-all duration-200"
+          >
+            {isLoading ? 'Sending...' : 'Send reset link'}
+          </button>
+        </div>
+      </form>
+
+      <div className="text-center mt-4">
+        <p className="text-sm text-gray-600 dark:text-gray-400">
+          Remembered it?{' '}
+          <Link href="/auth/login" className="font-medium text-primary-600 hover:text-primary-500">
+            Login
+          </Link>
+        </p>
+      </div>
+    </div>
+  );
+};
+
+export default ForgotPasswordForm;
@@ -2,14 +2,20 @@ import { useState, useEffect } from 'react';
 import { useRouter } from 'next/navigation';
 import Link from 'next/link';
 import { useForm } from 'react-hook-form';
-import { authAPI } from '@/services/api';
+import { authAPI, oidcAPI } from '@/services/api';
 import AlertMessage from '@/components/ui/AlertMessage';
+import TwoFactorForm from '@/components/auth/TwoFactorForm';
 
 type LoginFormData = {
   email: string;
   password: string;
 };
 
+type OIDCProvider = {
+  name: string;
+  display_name: string;
+};
+
 type AlertState = {
   type: 'success' | 'error' | 'warning' | 'info';
   message: string;
@@ -20,6 +26,9 @@ const LoginForm = () => {
   const [isLoading, setIsLoading] = useState(false);
   const [alert, setAlert] = useState<AlertState | null>(null);
   const [loginAttempts, setLoginAttempts] = useState(0);
+  const [unverifiedEmail, setUnverifiedEmail] = useState<string | null>(null);
+  const [challengeToken, setChallengeToken] = useState<string | null>(null);
+  const [providers, setProviders] = useState<OIDCProvider[]>([]);
 
   const {
     register,
@@ -28,6 +37,13 @@ const LoginForm = () => {
     reset,
   } = useForm<LoginFormData>();
 
+  // Load the external login providers, if any are configured
+  useEffect(() => {
+    oidcAPI.getProviders()
+      .then((response) => setProviders(response.data.providers || []))
+      .catch(() => setProviders([]));
+  }, []);
+
   // Clear alert after 5 seconds
   useEffect(() => {
     if (alert) {
@@ -38,27 +54,59 @@ const LoginForm = () => {
     }
   }, [alert]);
 
+  const completeLogin = (data: any) => {
+    // Store token in localStorage
+    localStorage.setItem('token', data.token);
+    localStorage.setItem('refresh_token', data.refresh_token);
+    localStorage.setItem('user', JSON.stringify(data.user));
+
+    // Show success message briefly before redirect
+    setAlert({
+      type: 'success',
+      message: 'Login successful! Redirecting to chat...'
+    });
+
+    // Redirect to chat page after a short delay
+    setTimeout(() => {
+      router.push('/chat');
+    }, 1000);
+  };
+
+  const resendVerification = async () => {
+    if (!unverifiedEmail) return;
+
+    try {
+      await authAPI.resendVerification(unverifiedEmail);
+      setAlert({
+        type: 'success',
+        message: 'A new verification email has been sent. Please check your inbox.'
+      });
+      setUnverifiedEmail(null);
+    } catch (err: any) {
+      setAlert({
+        type: 'warning',
+        message: err.response?.status === 429
+          ? 'Too many verification emails. Please try again later.'
+          : 'Failed to send the verification email. Please try again.'
+      });
+    }
+  };
+
   const onSubmit = async (data: LoginFormData) => {
     setIsLoading(true);
     setAlert(null);
+    setUnverifiedEmail(null);
 
     try {
       const response = await authAPI.login(data.email, data.password);
 
-      // Store token in localStorage
-      localStorage.setItem('token', response.data.token);
-      localStorage.setItem('user', JSON.stringify(response.data.user));
-
-      // Show success message briefly before redirect
-      setAlert({
-        type: 'success',
-        message: 'Login successful! Redirecting to chat...'
-      });
+      // Accounts with two-factor authentication need a code before tokens are issued
+      if (response.data.two_factor_required) {
+        setChallengeToken(response.data.challenge_token);
+        return;
+      }
 
-      // Redirect to chat page after a short delay
-      setTimeout(() => {
-        router.push('/chat');
-      }, 1000);
+      completeLogin(response.data);
     } catch (err: any) {
       // Increment login attempts
       setLoginAttempts(prev => prev + 1);
@@ -70,10 +118,31 @@ const LoginForm = () => {
             type: 'error',
             message: 'Invalid email or password. Please try again.'
           });
+        } else if (err.response.status === 403 && err.response.data?.error === 'Email not verified') {
+          setUnverifiedEmail(data.email);
+          setAlert({
+            type: 'warning',
+            message: 'Please verify your email address before logging in.'
+          });
+        } else if (err.response.status === 403) {
+          setAlert({
+            type: 'warning',
+            message: err.response.data?.error === 'Password reset required'
+              ? 'You must reset your password before logging in. Check your email for a reset link.'
+              : err.response.data?.error || 'You cannot log in to this account.'
+          });
+        } else if (err.response.status === 423) {
+          setAlert({
+            type: 'error',
+            message: 'This account is temporarily locked after too many failed logins. Reset your password or try again later.'
+          });
         } else if (err.response.status === 429) {
+          const retryAfter = Number(err.response.headers?.['retry-after']);
           setAlert({
             type: 'warning',
-            message: 'Too many login attempts. Please try again later.'
+            message: retryAfter > 0
+              ? `Too many login attempts. Please try again in ${retryAfter} seconds.`
+              : 'Too many login attempts. Please try again later.'
           });
         } else {
           setAlert({
@@ -94,7 +163,7 @@ const LoginForm = () => {
       }
 
       // If too many failed attempts, show a helpful message
-      if (loginAttempts >= 2) {
+      if (loginAttempts >= 2 && err.response?.status === 401) {
         setAlert({
           type: 'info',
           message: 'Having trouble logging in? You can reset your password or contact support.'
@@ -120,63 +189,115 @@ const LoginForm = () => {
         />
       )}
 
-      <form className="mt-8 space-y-6" onSubmit={handleSubmit(onSubmit)}>
-        <div>
-          <label htmlFor="email" className="block text-sm font-medium text-gray-700 dark:text-gray-300">
-            Email
-          </label>
-          <input
-            id="email"
-            type="email"
-            {...register('email', {
-              required: 'Email is required',
-              pattern: {
-                value: /^[A-Z0-9._%+-]+@[A-Z0-9.-]+\.[A-Z]{2,}$/i,
-                message: 'Invalid email address',
-              }
-            })}
-            className="mt-1 block w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm focus:outline-none focus:ring-primary-500 focus:border-primary-500"
-          />
-          {errors.email && (
-            <p className="mt-1 text-sm text-red-600">{errors.email.message}</p>
-          )}
+      {unverifiedEmail && (
+        <div className="text-center">
+          <button
+            type="button"
+            onClick={resendVerification}
+            className="text-sm font-medium text-primary-600 hover:text-primary-500"
+          >
+            Resend verification email
+          </button>
         </div>
+      )}
 
-        <div>
-          <label htmlFor="password" className="block text-sm font-medium text-gray-700 dark:text-gray-300">
-            Password
-          </label>
-          <input
-            id="password"
-            type="password"
-            {...register('password', { required: 'Password is required' })}
-            className="mt-1 block w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm focus:outline-none focus:ring-primary-500 focus:border-primary-500"
-          />
-          {errors.password && (
-            <p className="mt-1 text-sm text-red-600">{errors.password.message}</p>
-          )}
-        </div>
+      {challengeToken ? (
+        <TwoFactorForm
+          challengeToken={challengeToken}
+          onSuccess={completeLogin}
+          onCancel={(expired) => {
+            setChallengeToken(null);
+            if (expired) {
+              setAlert({
+                type: 'warning',
+                message: 'Your login has expired. Please enter your password again.'
+              });
+            }
+          }}
+        />
+      ) : (
+        <form className="mt-8 space-y-6" onSubmit={handleSubmit(onSubmit)}>
+          <div>
+            <label htmlFor="email" className="block text-sm font-medium text-gray-700 dark:text-gray-300">
+              Email
+            </label>
+            <input
+              id="email"
+              type="email"
+              {...register('email', {
+                required: 'Email is required',
+                pattern: {
+                  value: /^[A-Z0-9._%+-]+@[A-Z0-9.-]+\.[A-Z]{2,}$/i,
+                  message: 'Invalid email address',
+                }
+              })}
+              className="mt-1 block w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm focus:outline-none focus:ring-primary-500 focus:border-primary-500"
+            />
+            {errors.email && (
+              <p className="mt-1 text-sm text-red-600">{errors.email.message}</p>
+            )}
+          </div>
 
-        <div>
-          <button
-            type="submit"
-            disabled={isLoading}
-            className="w-full flex justify-center items-center py-2 px-4 border border-transparent rounded-md shadow-sm text-sm font-medium text-white bg-primary-600 hover:bg-primary-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-primary-500 disabled:opacity-50 transition-all duration-200"
-          >
-            {isLoading ? (
-              <>
-                <svg className="animate-spin -ml-1 mr-2 h-4 w-4 text-white" xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 24 24">
-                  <circle className="opacity-25" cx="12" cy="12" r="10" stroke="currentColor" strokeWidth="4"></circle>
-                  <path className="opacity-75" fill="currentColor" d="M4 12a8 8 0 018-8V0C5.373 0 0 5.373 0 12h4zm2 5.291A7.962 7.962 0 014 12H0c0 3.042 1.135 5.824 3 7.938l3-2.647z"></path>
-                </svg>
-                Logging in...
-              </>
-            ) : (
-              'Login'
+          <div>
+            <label htmlFor="password" className="block text-sm font-medium text-gray-700 dark:text-gray-300">
+              Password
+            </label>
+            <input
+              id="password"
+              type="password"
+              {...register('password', { required: 'Password is required' })}
+              className="mt-1 block w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm focus:outline-none focus:ring-primary-500 focus:border-primary-500"
+            />
+            {errors.password && (
+              <p className="mt-1 text-sm text-red-600">{errors.password.message}</p>
             )}
-          </button>
+            <div className="mt-1 text-right">
+              <Link href="/auth/forgot-password" className="text-sm font-medium text-primary-600 hover:text-primary-500">
+                Forgot password?
+              </Link>
+            </div>
+          </div>
+
+          <div>
+            <button
+              type="submit"
+              disabled={isLoading}
+              className="w-full flex justify-center items-center py-2 px-4 border border-transparent rounded-md shadow-sm text-sm font-medium text-white bg-primary-600 hover:bg-primary-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-primary-500 disabled:opacity-50 transition-all duration-200"
+            >
+              {isLoading ? (
+                <>
+                  <svg className="animate-spin -ml-1 mr-2 h-4 w-4 text-white" xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 24 24">
+                    <circle className="opacity-25" cx="12" cy="12" r="10" stroke="currentColor" strokeWidth="4"></circle>
+                    <path className="opacity-75" fill="currentColor" d="M4 12a8 8 0 018-8V0C5.373 0 0 5.373 0 12h4zm2 5.291A7.962 7.962 0 014 12H0c0 3.042 1.135 5.824 3 7.938l3-2.647z"></path>
+                  </svg>
+                  Logging in...
+                </>
+              ) : (
+                'Login'
+              )}
+            </button>
+          </div>
+        </form>
+      )}
+
+      {!challengeToken && providers.length > 0 && (
+        <div className="space-y-3">
+          <div className="flex items-center">
+            <div className="flex-grow border-t border-gray-300" />
+            <span className="mx-3 text-sm text-gray-500">or</span>
+            <div className="flex-grow border-t border-gray-300" />
+          </div>
+          {providers.map((provider) => (
+            <a
+              key={provider.name}
+              href={oidcAPI.loginURL(provider.name)}
+              className="w-full flex justify-center py-2 px-4 border border-gray-300 rounded-md shadow-sm text-sm font-medium text-gray-700 dark:text-gray-300 hover:bg-gray-50 dark:hover:bg-gray-700 transition-all duration-200"
+            >
+              Continue with {provider.display_name}
+            </a>
+          ))}
         </div>
-      </form>
+      )}
 
       <div className="text-center mt-4">
         <p className="text-sm text-gray-600 dark:text-gray-400">
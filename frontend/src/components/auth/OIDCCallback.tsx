@@ -0,0 +1,86 @@
+import { useEffect, useRef, useState } from 'react';
+import { useRouter } from 'next/navigation';
+import Link from 'next/link';
+import { oidcAPI } from '@/services/api';
+import AlertMessage from '@/components/ui/AlertMessage';
+import TwoFactorForm from '@/components/auth/TwoFactorForm';
+
+type OIDCCallbackProps = {
+  code: string;
+};
+
+const OIDCCallback = ({ code }: OIDCCallbackProps) => {
+  const router = useRouter();
+  const [error, setError] = useState<string | null>(null);
+  const [challengeToken, setChallengeToken] = useState<string | null>(null);
+  // The login code can only be exchanged once
+  const exchanged = useRef(false);
+
+  const completeLogin = (data: any) => {
+    localStorage.setItem('token', data.token);
+    localStorage.setItem('refresh_token', data.refresh_token);
+    localStorage.setItem('user', JSON.stringify(data.user));
+    router.push('/chat');
+  };
+
+  useEffect(() => {
+    if (exchanged.current) return;
+    exchanged.current = true;
+
+    if (!code) {
+      setError('This login link is invalid. Please log in again.');
+      return;
+    }
+
+    oidcAPI.exchange(code)
+      .then((response) => {
+        // Accounts with two-factor authentication need a code before tokens are issued
+        if (response.data.two_factor_required) {
+          setChallengeToken(response.data.challenge_token);
+          return;
+        }
+        completeLogin(response.data);
+      })
+      .catch((err: any) => {
+        if (err.response?.status === 403 && err.response.data?.error === 'Email not verified') {
+          setError('Please verify your email address before logging in.');
+        } else if (err.response?.status === 403) {
+          setError(err.response.data?.error || 'You cannot log in to this account.');
+        } else if (err.response?.status === 423 || err.response?.status === 429) {
+          // Too many wrong two-factor codes
+          setError(err.response.data?.error || 'Too many failed logins. Please try again later.');
+        } else {
+          setError('Your login has expired. Please log in again.');
+        }
+      });
+  }, [code]);
+
+  return (
+    <div className="w-full max-w-md p-8 space-y-8 bg-white dark:bg-gray-800 rounded-lg shadow-md">
+      <div className="text-center">
+        <h1 className="text-3xl font-bold">Login to GinChat</h1>
+      </div>
+
+      {error ? (
+        <>
+          <AlertMessage type="error" message={error} onClose={() => setError(null)} />
+          <div className="text-center">
+            <Link href="/auth/login" className="text-sm font-medium text-primary-600 hover:text-primary-500">
+              Back to login
+            </Link>
+          </div>
+        </>
+      ) : challengeToken ? (
+        <TwoFactorForm
+          challengeToken={challengeToken}
+          onSuccess={completeLogin}
+          onCancel={() => router.push('/auth/login')}
+        />
+      ) : (
+        <p className="text-center text-gray-600 dark:text-gray-400">Signing you in...</p>
+      )}
+    </div>
+  );
+};
+
+export default OIDCCallback;
@@ -49,8 +49,21 @@ const RegisterForm = () => {
     try {
       const response = await authAPI.register(data.username, data.email, data.password);
 
+      // Accounts that must verify their email first get no tokens
+      if (response.data.verification_required) {
+        setAlert({
+          type: 'info',
+          message: 'Registration successful! Check your email for a verification link before logging in.'
+        });
+        setTimeout(() => {
+          router.push('/auth/login');
+        }, 3000);
+        return;
+      }
+
       // Store token in localStorage
       localStorage.setItem('token', response.data.token);
+      localStorage.setItem('refresh_token', response.data.refresh_token);
       localStorage.setItem('user', JSON.stringify(response.data.user));
 
       // Show success message briefly before redirect
@@ -170,8 +183,8 @@ const RegisterForm = () => {
             {...register('password', {
               required: 'Password is required',
               minLength: {
-                value: 6,
-                message: 'Password must be at least 6 characters',
+                value: 8,
+                message: 'Password must be at least 8 characters',
               }
             })}
             className="mt-1 block w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm focus:outline-none focus:ring-primary-500 focus:border-primary-500"
@@ -0,0 +1,141 @@
+import { useState } from 'react';
+import { useRouter } from 'next/navigation';
+import Link from 'next/link';
+import { useForm } from 'react-hook-form';
+import { authAPI } from '@/services/api';
+import AlertMessage from '@/components/ui/AlertMessage';
+
+type ResetPasswordFormData = {
+  password: string;
+  confirmPassword: string;
+};
+
+type AlertState = {
+  type: 'success' | 'error' | 'warning' | 'info';
+  message: string;
+};
+
+type ResetPasswordFormProps = {
+  token: string;
+};
+
+const ResetPasswordForm = ({ token }: ResetPasswordFormProps) => {
+  const router = useRouter();
+  const [isLoading, setIsLoading] = useState(false);
+  const [alert, setAlert] = useState<AlertState | null>(
+    token ? null : { type: 'error', message: 'This reset link is invalid. Please request a new one.' }
+  );
+
+  const {
+    register,
+    handleSubmit,
+    formState: { errors },
+    watch,
+  } = useForm<ResetPasswordFormData>();
+
+  const password = watch('password');
+
+  const onSubmit = async (data: ResetPasswordFormData) => {
+    setIsLoading(true);
+    setAlert(null);
+
+    try {
+      await authAPI.resetPassword(token, data.password);
+
+      setAlert({
+        type: 'success',
+        message: 'Your password has been reset! Redirecting to login...'
+      });
+
+      setTimeout(() => {
+        router.push('/auth/login');
+      }, 1500);
+    } catch (err: any) {
+      setAlert({
+        type: 'error',
+        message: err.response?.data?.error || 'Network error. Please check your connection and try again.'
+      });
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  return (
+    <div className="w-full max-w-md p-8 space-y-8 bg-white dark:bg-gray-800 rounded-lg shadow-md">
+      <div className="text-center">
+        <h1 className="text-3xl font-bold">Choose a new password</h1>
+        <p className="mt-2 text-gray-600 dark:text-gray-400">You will be signed out of all your devices</p>
+      </div>
+
+      {alert && (
+        <AlertMessage
+          type={alert.type}
+          message={alert.message}
+          onClose={() => setAlert(null)}
+        />
+      )}
+
+      <form className="mt-8 space-y-6" onSubmit={handleSubmit(onSubmit)}>
+        <div>
+          <label htmlFor="password" className="block text-sm font-medium text-gray-700 dark:text-gray-300">
+            New Password
+          </label>
+          <input
+            id="password"
+            type="password"
+            {...register('password', {
+              required: 'Password is required',
+              minLength: {
+                value: 8,
+                message: 'Password must be at least 8 characters',
+              }
+            })}
+            className="mt-1 block w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm focus:outline-none focus:ring-primary-500 focus:border-primary-500"
+          />
+          {errors.password && (
+            <p className="mt-1 text-sm text-red-600">{errors.password.message}</p>
+          )}
+        </div>
+
+        <div>
+          <label htmlFor="confirmPassword" className="block text-sm font-medium text-gray-700 dark:text-gray-300">
+            Confirm Password
+          </label>
+          <input
+            id="confirmPassword"
+            type="password"
+            {...register('confirmPassword', {
+              required: 'Please confirm your password',
+              validate: value => value === password || 'Passwords do not match'
+            })}
+            className="mt-1 block w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm focus:outline-none focus:ring-primary-500 focus:border-primary-500"
+          />
+          {errors.confirmPassword && (
+            <p className="mt-1 text-sm text-red-600">{errors.confirmPassword.message}</p>
+          )}
+        </div>
+
+        <div>
+          <button
+            type="submit"
+            disabled={isLoading || !token}
+            className="w-full flex justify-center items-center py-2 px-4 border border-transparent rounded-md shadow-sm text-sm font-medium text-white bg-primary-600 hover:bg-primary-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-primary-500 disabled:opacity-50 transition-all duration-200"
+          >
+            {isLoading ? 'Resetting...' : 'Reset password'}
+          </button>
+        </div>
+      </form>
+
+      <div className="text-center mt-4">
+        <p className="text-sm text-gray-600 dark:text-gray-400">
+          Link expired?{' '}
+          <Link href="/auth/forgot-password" className="font-medium text-primary-600 hover:text-primary-500">
+            Request a new one
+          </Link>
+        </p>
+      </div>
+    </div>
+  );
+};
+
+export default ResetPasswordForm;
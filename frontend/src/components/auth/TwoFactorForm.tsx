@@ -0,0 +1,123 @@
+import { useState } from 'react';
+import { useForm } from 'react-hook-form';
+import { authAPI } from '@/services/api';
+import AlertMessage from '@/components/ui/AlertMessage';
+
+type TwoFactorFormData = {
+  code: string;
+};
+
+type TwoFactorFormProps = {
+  challengeToken: string;
+  onSuccess: (data: any) => void;
+  onCancel: (expired?: boolean) => void;
+};
+
+const TwoFactorForm = ({ challengeToken, onSuccess, onCancel }: TwoFactorFormProps) => {
+  const [isLoading, setIsLoading] = useState(false);
+  const [error, setError] = useState<string | null>(null);
+  const [useRecoveryCode, setUseRecoveryCode] = useState(false);
+
+  const {
+    register,
+    handleSubmit,
+    formState: { errors },
+    reset,
+  } = useForm<TwoFactorFormData>();
+
+  const onSubmit = async (data: TwoFactorFormData) => {
+    setIsLoading(true);
+    setError(null);
+
+    try {
+      const response = await authAPI.verifyTwoFactor(challengeToken, data.code.trim());
+      onSuccess(response.data);
+    } catch (err: any) {
+      if (err.response?.status === 401 && err.response.data?.error === 'Invalid code') {
+        setError('Invalid code. Please try again.');
+        reset();
+      } else if (err.response?.status === 401) {
+        // The challenge expired or was invalidated; start over with the password
+        onCancel(true);
+      } else {
+        setError(err.response?.data?.error || 'An error occurred during login');
+      }
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  return (
+    <div className="space-y-6">
+      <p className="text-sm text-gray-600 dark:text-gray-400">
+        {useRecoveryCode
+          ? 'Enter one of your recovery codes. Each code can only be used once.'
+          : 'Enter the 6-digit code from your authenticator app.'}
+      </p>
+
+      {error && (
+        <AlertMessage
+          type="error"
+          message={error}
+          onClose={() => setError(null)}
+        />
+      )}
+
+      <form className="space-y-6" onSubmit={handleSubmit(onSubmit)}>
+        <div>
+          <label htmlFor="code" className="block text-sm font-medium text-gray-700 dark:text-gray-300">
+            {useRecoveryCode ? 'Recovery code' : 'Authentication code'}
+          </label>
+          <input
+            id="code"
+            type="text"
+            autoComplete="one-time-code"
+            inputMode={useRecoveryCode ? 'text' : 'numeric'}
+            autoFocus
+            {...register('code', {
+              required: 'Code is required',
+              pattern: useRecoveryCode ? undefined : {
+                value: /^\d{6}$/,
+                message: 'Code must be 6 digits',
+              },
+            })}
+            className="mt-1 block w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm focus:outline-none focus:ring-primary-500 focus:border-primary-500"
+          />
+          {errors.code && (
+            <p className="mt-1 text-sm text-red-600">{errors.code.message}</p>
+          )}
+        </div>
+
+        <button
+          type="submit"
+          disabled={isLoading}
+          className="w-full flex justify-center items-center py-2 px-4 border border-transparent rounded-md shadow-sm text-sm font-medium text-white bg-primary-600 hover:bg-primary-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-primary-500 disabled:opacity-50 transition-all duration-200"
+        >
+          {isLoading ? 'Verifying...' : 'Verify'}
+        </button>
+      </form>
+
+      <div className="flex justify-between text-sm">
+        <button
+          type="button"
+          onClick={() => {
+            setUseRecoveryCode(!useRecoveryCode);
+            reset();
+          }}
+          className="font-medium text-primary-600 hover:text-primary-500"
+        >
+          {useRecoveryCode ? 'Use authenticator app' : 'Use a recovery code'}
+        </button>
+        <button
+          type="button"
+          onClick={() => onCancel()}
+          className="font-medium text-gray-600 hover:text-gray-500"
+        >
+          Back to login
+        </button>
+      </div>
+    </div>
+  );
+};
+
+export default TwoFactorForm;
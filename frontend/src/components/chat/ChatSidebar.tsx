@@ -26,6 +26,7 @@ const ChatSidebar: React.FC<ChatSidebarProps> = ({
 
   const handleLogout = () => {
     localStorage.removeItem('token');
+    localStorage.removeItem('refresh_token');
     localStorage.removeItem('user');
     router.push('/auth/login');
   };
@@ -24,17 +24,56 @@ api.interceptors.request.use(
   }
 );
 
+// A single in-flight refresh shared by every request that hits a 401
+let refreshPromise: Promise<string> | null = null;
+
+const refreshAccessToken = (): Promise<string> => {
+  if (!refreshPromise) {
+    const refreshToken = localStorage.getItem('refresh_token');
+    refreshPromise = (refreshToken
+      ? axios.post('/api/auth/refresh', { refresh_token: refreshToken }).then((response) => {
+          localStorage.setItem('token', response.data.token);
+          localStorage.setItem('refresh_token', response.data.refresh_token);
+          return response.data.token as string;
+        })
+      : Promise.reject(new Error('No refresh token'))
+    ).finally(() => {
+      refreshPromise = null;
+    });
+  }
+  return refreshPromise;
+};
+
 // Add a response interceptor to handle errors
 api.interceptors.response.use(
   (response) => {
     return response;
   },
-  (error) => {
+  async (error) => {
+    // Retry once with a refreshed access token when the current one has expired
+    const original = error.config;
+    if (
+      error.response?.status === 401 &&
+      original &&
+      !original._retry &&
+      !original.url?.startsWith('/auth/')
+    ) {
+      original._retry = true;
+      try {
+        const token = await refreshAccessToken();
+        original.headers.Authorization = `Bearer ${token}`;
+        return api(original);
+      } catch {
+        // Fall through to the session-expired handling below
+      }
+    }
+
     // Handle 401 Unauthorized errors (token expired or invalid)
     if (error.response) {
       if (error.response.status === 401 && window.location.pathname !== '/auth/login') {
         // Clear local storage and redirect to login
         localStorage.removeItem('token');
+        localStorage.removeItem('refresh_token');
         localStorage.removeItem('user');
         window.location.href = '/auth/login?session=expired';
       }
@@ -55,14 +94,29 @@ export const authAPI = {
   login: (email: string, password: string) => {
     return api.post('/auth/login', { email, password });
   },
+  verifyTwoFactor: (challengeToken: string, code: string) => {
+    return api.post('/auth/login/2fa', { challenge_token: challengeToken, code });
+  },
   register: (username: string, email: string, password: string) => {
     return api.post('/auth/register', { username, email, password });
   },
+  forgotPassword: (email: string) => {
+    return api.post('/auth/password/forgot', { email });
+  },
+  resetPassword: (token: string, password: string) => {
+    return api.post('/auth/password/reset', { token, password });
+  },
+  resendVerification: (email: string) => {
+    return api.post('/auth/verify/resend', { email });
+  },
   logout: async () => {
     try {
-      const response = await api.post('/auth/logout');
+      const response = await api.post('/auth/logout', {
+        refresh_token: localStorage.getItem('refresh_token') || undefined,
+      });
       // Clear local storage
       localStorage.removeItem('token');
+      localStorage.removeItem('refresh_token');
       localStorage.removeItem('user');
       // Redirect to login with logout parameter
       window.location.href = '/auth/login?session=logout';
@@ -70,6 +124,7 @@ export const authAPI = {
     } catch (error) {
       // Still clear storage and redirect even if the API call fails
       localStorage.removeItem('token');
+      localStorage.removeItem('refresh_token');
       localStorage.removeItem('user');
       window.location.href = '/auth/login?session=logout';
       throw error;
@@ -77,6 +132,62 @@ export const authAPI = {
   },
 };
 
+// Session API
+export const sessionAPI = {
+  getSessions: () => {
+    return api.get('/auth/sessions');
+  },
+  revokeSession: (sessionId: string) => {
+    return api.delete(`/auth/sessions/${sessionId}`);
+  },
+};
+
+// Account API
+export const accountAPI = {
+  changePassword: (currentPassword: string, newPassword: string) => {
+    return api.put('/users/me/password', {
+      current_password: currentPassword,
+      new_password: newPassword,
+    });
+  },
+  changeEmail: (newEmail: string, password: string) => {
+    return api.put('/users/me/email', { new_email: newEmail, password });
+  },
+};
+
+// Two-factor authentication API
+export const twoFactorAPI = {
+  getStatus: () => {
+    return api.get('/auth/2fa');
+  },
+  setup: () => {
+    return api.post('/auth/2fa/setup');
+  },
+  confirm: (code: string) => {
+    return api.post('/auth/2fa/confirm', { code });
+  },
+  disable: (password: string, code: string) => {
+    return api.post('/auth/2fa/disable', { password, code });
+  },
+  regenerateRecoveryCodes: (code: string) => {
+    return api.post('/auth/2fa/recovery-codes', { code });
+  },
+};
+
+// External login (OpenID Connect) API
+export const oidcAPI = {
+  getProviders: () => {
+    return api.get('/auth/oidc/providers');
+  },
+  // Providers are reached by a full page navigation, not an API call
+  loginURL: (provider: string) => {
+    return `/api/auth/oidc/${encodeURIComponent(provider)}/login`;
+  },
+  exchange: (code: string) => {
+    return api.post('/auth/oidc/exchange', { code });
+  },
+};
+
 // Chatroom API
 export const chatroomAPI = {
   getChatrooms: () => {
@@ -102,6 +213,17 @@ export const messageAPI = {
       media_url: mediaURL,
     });
   },
+  markRead: (chatroomId: string, messageId: string) => {
+    return api.post(`/chatrooms/${chatroomId}/read`, { message_id: messageId });
+  },
+};
+
+// Event stream API
+export const eventStreamAPI = {
+  // A single-use ticket that authenticates the EventSource, which cannot send headers
+  getTicket: () => {
+    return api.post('/events/ticket');
+  },
 };
 
 export default api;
@@ -0,0 +1,175 @@
+'use client';
+
+import { WebSocketMessage } from '@/types';
+import { eventStreamAPI } from '@/services/api';
+
+// Event types sent over the stream, named after the WebSocket message types
+const EVENT_TYPES = [
+  'connected',
+  'new_message',
+  'read_receipt',
+  'presence_changed',
+  'typing_start',
+  'typing_stop',
+  'resync_required',
+  'error',
+];
+
+// Server-Sent Events fallback for networks that block WebSocket upgrades.
+// It delivers the same messages as the WebSocket, read-only.
+class EventStreamService {
+  private source: EventSource | null = null;
+  private rooms: string[] = [];
+  // Per-chatroom resume cursors from the last event ID received
+  private lastEventId = '';
+  private reconnectAttempts = 0;
+  private maxReconnectAttempts = 5;
+  private reconnectTimeout = 3000; // 3 seconds
+  private reconnectTimer: ReturnType<typeof setTimeout> | null = null;
+  private messageListeners: ((message: WebSocketMessage) => void)[] = [];
+  private connectionListeners: ((connected: boolean) => void)[] = [];
+
+  // Connect to the event stream, following the given chatrooms (all of the user's by default)
+  async connect(rooms: string[] = []) {
+    if (this.source) {
+      return;
+    }
+    this.rooms = rooms;
+
+    // Each connection needs a fresh ticket; a used ticket cannot reopen the stream
+    let ticket: string;
+    try {
+      const response = await eventStreamAPI.getTicket();
+      ticket = response.data.ticket;
+    } catch (error) {
+      console.error('Failed to get an event stream ticket:', error);
+      this.reconnect();
+      return;
+    }
+
+    const params = new URLSearchParams({ ticket });
+    if (this.rooms.length > 0) {
+      params.set('rooms', this.rooms.join(','));
+    }
+    if (this.lastEventId) {
+      params.set('last_event_id', this.lastEventId);
+    }
+
+    this.source = new EventSource(`/api/events?${params.toString()}`);
+
+    this.source.addEventListener('open', () => {
+      console.log('Connected to event stream');
+      this.reconnectAttempts = 0;
+      this.notifyConnectionListeners(true);
+    });
+
+    EVENT_TYPES.forEach((type) => {
+      this.source?.addEventListener(type, (event) => {
+        const messageEvent = event as MessageEvent;
+        // Connection failures are also dispatched as error events, without data
+        if (typeof messageEvent.data !== 'string') {
+          return;
+        }
+        if (messageEvent.lastEventId) {
+          this.lastEventId = messageEvent.lastEventId;
+        }
+
+        try {
+          const message = JSON.parse(messageEvent.data) as WebSocketMessage;
+          this.notifyMessageListeners(message);
+        } catch (error) {
+          console.error('Failed to parse event stream message:', error);
+        }
+      });
+    });
+
+    // The browser would retry with the spent ticket, so reconnect with a new one instead
+    this.source.addEventListener('error', (event) => {
+      // Error events sent by the server carry data and are handled above
+      if (typeof (event as MessageEvent).data === 'string') {
+        return;
+      }
+      console.log('Disconnected from event stream');
+      this.source?.close();
+      this.source = null;
+      this.notifyConnectionListeners(false);
+      this.reconnect();
+    });
+  }
+
+  // Disconnect from the event stream
+  disconnect() {
+    if (this.reconnectTimer) {
+      clearTimeout(this.reconnectTimer);
+      this.reconnectTimer = null;
+    }
+    if (this.source) {
+      this.source.close();
+      this.source = null;
+    }
+    this.lastEventId = '';
+  }
+
+  // Add a message listener
+  addMessageListener(listener: (message: WebSocketMessage) => void) {
+    this.messageListeners.push(listener);
+  }
+
+  // Remove a message listener
+  removeMessageListener(listener: (message: WebSocketMessage) => void) {
+    this.messageListeners = this.messageListeners.filter((l) => l !== listener);
+  }
+
+  // Add a connection listener
+  addConnectionListener(listener: (connected: boolean) => void) {
+    this.connectionListeners.push(listener);
+  }
+
+  // Remove a connection listener
+  removeConnectionListener(listener: (connected: boolean) => void) {
+    this.connectionListeners = this.connectionListeners.filter((l) => l !== listener);
+  }
+
+  // Notify all message listeners
+  private notifyMessageListeners(message: WebSocketMessage) {
+    this.messageListeners.forEach((listener) => {
+      try {
+        listener(message);
+      } catch (error) {
+        console.error('Error in message listener:', error);
+      }
+    });
+  }
+
+  // Notify all connection listeners
+  private notifyConnectionListeners(connected: boolean) {
+    this.connectionListeners.forEach((listener) => {
+      try {
+        listener(connected);
+      } catch (error) {
+        console.error('Error in connection listener:', error);
+      }
+    });
+  }
+
+  // Attempt to reconnect, resuming from the last event ID
+  private reconnect() {
+    if (this.reconnectAttempts >= this.maxReconnectAttempts) {
+      console.error('Max reconnect attempts reached');
+      return;
+    }
+
+    this.reconnectAttempts++;
+    console.log(`Attempting to reconnect (${this.reconnectAttempts}/${this.maxReconnectAttempts})...`);
+
+    this.reconnectTimer = setTimeout(() => {
+      this.reconnectTimer = null;
+      this.connect(this.rooms);
+    }, this.reconnectTimeout);
+  }
+}
+
+// Create a singleton instance
+const eventStreamService = new EventStreamService();
+
+export default eventStreamService;
@@ -85,9 +85,17 @@ class WebSocketService {
     }
   }
 
-  // Send a chat message
-  sendChatMessage(chatroomId: string, messageType: string, textContent?: string, mediaURL?: string) {
+  // Send a chat message. Pass the same clientMessageId when retrying so the server
+  // acknowledges the stored message instead of creating a duplicate.
+  sendChatMessage(
+    chatroomId: string,
+    messageType: string,
+    textContent?: string,
+    mediaURL?: string,
+    clientMessageId: string = crypto.randomUUID(),
+  ) {
     this.send({
+      id: clientMessageId,
       type: 'chat_message',
       chatroom_id: chatroomId,
       data: {
@@ -96,6 +104,50 @@ class WebSocketService {
         media_url: mediaURL,
       },
     });
+    return clientMessageId;
+  }
+
+  // Subscribe to a chatroom's messages. Pass the last message ID seen to have
+  // anything missed while disconnected replayed before live delivery resumes.
+  subscribe(chatroomId: string, lastMessageId?: string) {
+    this.send({
+      type: 'subscribe',
+      chatroom_id: chatroomId,
+      data: lastMessageId ? { last_message_id: lastMessageId } : undefined,
+    });
+  }
+
+  // Unsubscribe from a chatroom's messages
+  unsubscribe(chatroomId: string) {
+    this.send({
+      type: 'unsubscribe',
+      chatroom_id: chatroomId,
+    });
+  }
+
+  // Mark a chatroom's messages as read up to a message
+  sendRead(chatroomId: string, messageId: string) {
+    this.send({
+      type: 'read',
+      chatroom_id: chatroomId,
+      data: { message_id: messageId },
+    });
+  }
+
+  // Tell the other members of a chatroom that the user started typing
+  sendTypingStart(chatroomId: string) {
+    this.send({
+      type: 'typing_start',
+      chatroom_id: chatroomId,
+    });
+  }
+
+  // Tell the other members of a chatroom that the user stopped typing
+  sendTypingStop(chatroomId: string) {
+    this.send({
+      type: 'typing_stop',
+      chatroom_id: chatroomId,
+    });
   }
 
   // Add a message listener
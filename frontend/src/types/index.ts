@@ -6,6 +6,8 @@ export interface User {
   role: string;
   status: string;
   avatar_url?: string;
+  email_verified?: boolean;
+  two_factor_enabled?: boolean;
   created_at: string;
 }
 
@@ -38,6 +40,7 @@ export interface Chatroom {
   created_by: number;
   created_at: string;
   members: ChatroomMember[];
+  unread_count?: number;
 }
 
 export interface CreateChatroomRequest {
@@ -62,6 +65,9 @@ export interface Message {
   text_content?: string;
   media_url?: string;
   sent_at: string;
+  client_message_id?: string;
+  edited?: boolean;
+  edited_at?: string;
 }
 
 export interface SendMessageRequest {
@@ -74,13 +80,33 @@ export interface MessageResponse {
   message: Message;
 }
 
+export interface ReadState {
+  chatroom_id: string;
+  user_id: number;
+  username: string;
+  last_read_message_id: string;
+  read_at: string;
+}
+
+export interface Session {
+  id: string;
+  device: string;
+  user_agent: string;
+  ip_address: string;
+  last_seen_at: string;
+  created_at: string;
+  current: boolean;
+}
+
 export interface MessagesResponse {
   messages: Message[];
+  read_states?: ReadState[];
 }
 
 // WebSocket types
 export interface WebSocketMessage {
+  id?: string;
   type: string;
   chatroom_id?: string;
-  data: any;
+  data?: any;
 }